@@ -12,128 +12,602 @@ import (
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
 	"io"
+	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
 	"q-jam.nl/c/c-client/package_manager"
+	"sort"
+	"strings"
 )
 
+// The dependencies are pairs of indexes in Packages, like in Report. Names
+// are without the leading slash, the state is like running or exited, the
+// created time is a Unix time and the image digests are the repository
+// digests of the image, like busybox@sha256:... The restart policy is like no
+// or on-failure:3. When ImagePackages is set the container has the packages of
+// its image, which are in Report.Images, and Packages is empty. Partially
+// installed packages are in Partial instead of Packages, like in Report.
 type DockerContainer struct {
-	ID       string                    `json:"i"`
-	Image    string                    `json:"m"`
-	Packages []package_manager.Package `json:"p"`
+	ID            string                     `json:"i"`
+	Names         []string                   `json:"n,omitempty"`
+	Image         string                     `json:"m"`
+	ImageID       string                     `json:"j,omitempty"`
+	ImageDigests  []string                   `json:"e,omitempty"`
+	Labels        map[string]string          `json:"l,omitempty"`
+	State         string                     `json:"s,omitempty"`
+	Created       int64                      `json:"t,omitempty"`
+	Command       string                     `json:"x,omitempty"`
+	RestartPolicy string                     `json:"r,omitempty"`
+	OsRelease     *package_manager.OsRelease `json:"o,omitempty"`
+	ImagePackages bool                       `json:"b,omitempty"`
+	Packages      []package_manager.Package  `json:"p"`
+	Dependencies  [][2]int                   `json:"g,omitempty"`
+	Partial       []package_manager.Package  `json:"q,omitempty"`
 }
 
-// Get packages in all docker containers for provided package managers
-func GetDockerPackages(packageManagers []package_manager.PackageManager) (map[string]DockerContainer, error) {
-	cli, err := client.NewClient("unix:///var/run/docker.sock", "v1.22", nil, nil)
+// Which containers and images to scan
+type DockerOptions struct {
+	// Also scan stopped and created containers, not only running ones
+	All bool
+	// Also scan the images without containers
+	Images bool
+}
+
+// The kind of a change to the file system of a container, see ContainerDiff
+const dockerChangeDelete = 2
+
+// Get a client for the local docker daemon
+func newDockerClient() (*client.Client, error) {
+	return client.NewClient("unix:///var/run/docker.sock", "v1.22", nil, nil)
+}
+
+// Get packages in all docker containers and images for provided package
+// managers. A container which doesn't change any of the files needed in the
+// writable layer on top of its image has the packages of its image. Of the
+// other containers only the package managers whose files are changed are
+// scanned, the packages of the others are those of the image. Every image is
+// scanned once, the images whose packages containers share and with
+// DockerOptions.Images all images are returned keyed by image ID. When
+// running as root on the docker host the files are read directly from the
+// overlay2 storage driver, only otherwise through the API.
+func GetDockerPackages(packageManagers []package_manager.PackageManager, options DockerOptions) (map[string]DockerContainer, map[string]DockerImage, error) {
+	cli, err := newDockerClient()
 	if err != nil {
-		return nil, fmt.Errorf("error getting docker packages: %v", err)
+		return nil, nil, fmt.Errorf("error getting docker packages: %v", err)
 	}
 
-	containers, err := cli.ContainerList(context.Background(), types.ContainerListOptions{})
+	containers, err := cli.ContainerList(context.Background(), types.ContainerListOptions{All: options.All})
 	if err != nil {
-		return nil, fmt.Errorf("error getting docker packages: %v", err)
+		return nil, nil, fmt.Errorf("error getting docker packages: %v", err)
 	}
 
+	images := newDockerImageScanner(cli, packageManagers)
 	result := make(map[string]DockerContainer)
 
 	// Iterate all containers
 	for _, container := range containers {
-		var allPackages []package_manager.Package
+		var containerJSON *types.ContainerJSON
+		if inspected, err := cli.ContainerInspect(context.Background(), container.ID); err != nil {
+			Log.Debugf("error inspecting docker container %s: %v", container.ID, err)
+		} else {
+			containerJSON = &inspected
+		}
+
+		dockerContainer := getDockerContainerMetadata(container, containerJSON, images)
+
+		scanned := packageManagers
+		image, changed, ok := getDockerImageChanges(cli, container, packageManagers, images)
+		if ok && changed == nil {
+			// The packages are reported once for the image
+			dockerContainer.OsRelease = image.OsRelease
+			dockerContainer.ImagePackages = true
+			dockerContainer.Packages = []package_manager.Package{}
+
+			result[container.ID] = dockerContainer
+			continue
+		}
+		if ok {
+			scanned = changed
+		}
+
+		packages, partialPackages, osRelease, err := scanDockerContainer(cli, container, containerJSON, scanned)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error getting docker packages: %v", err)
+		}
+
+		if ok {
+			packages = append(packagesOfOthers(image.Packages, changed), packages...)
+			partialPackages = append(packagesOfOthers(image.Partial, changed), partialPackages...)
+		}
+
+		dockerContainer.OsRelease = osRelease
+		dockerContainer.Packages = packages
+		dockerContainer.Dependencies = package_manager.NewDependencyGraph(packages).Edges()
+		dockerContainer.Partial = partialPackages
+
+		result[container.ID] = dockerContainer
+	}
+
+	if options.Images {
+		// The containers are reported without the other images when the
+		// images can't be listed
+		imageSummaries, err := cli.ImageList(context.Background(), types.ImageListOptions{})
+		if err != nil {
+			Log.Warnf("error getting docker images: %v", err)
+		}
+
+		for _, imageSummary := range imageSummaries {
+			images.scan(imageSummary.ID)
+		}
+
+		return result, images.images, nil
+	}
+
+	// Otherwise only the images whose packages containers share are reported,
+	// not those only scanned for containers changing them
+	sharedImages := make(map[string]DockerImage)
+	for _, dockerContainer := range result {
+		if dockerContainer.ImagePackages {
+			sharedImages[dockerContainer.ImageID] = images.images[dockerContainer.ImageID]
+		}
+	}
+
+	return result, sharedImages, nil
+}
+
+// Get the packages of the package managers and the OS release of a container.
+// The files are read from the storage driver directly when possible,
+// otherwise the files needed are copied into a temporary directory.
+func scanDockerContainer(cli *client.Client, container types.Container, containerJSON *types.ContainerJSON, packageManagers []package_manager.PackageManager) ([]package_manager.Package, []package_manager.Package, *package_manager.OsRelease, error) {
+	description := fmt.Sprintf("docker container %s (%s)", container.ID, container.Image)
+
+	if fsys, ok := openDockerOverlay(containerJSON); ok {
+		packages, partialPackages, osRelease := scanFileSystem(fsys, packageManagers, description)
+
+		return packages, partialPackages, osRelease, nil
+	}
+
+	// Files of all package managers are copied into a single temporary
+	// directory that mirrors the container file system
+	root := TempFileName("dr-")
+
+	for _, group := range groupFileSpecs(neededFiles(packageManagers)) {
+		err := copyFromDockerContainer(cli, container, group, root)
+		if err != nil {
+			Log.Tracef("%v", err)
+		}
+	}
+
+	packages, partialPackages, osRelease := scanFileSystem(package_manager.DirFS(root), packageManagers, description)
+
+	// Delete the temporary directory
+	err := os.RemoveAll(root)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return packages, partialPackages, osRelease, nil
+}
+
+// Get the image of a container and the package managers whose files the
+// writable layer of the container changes, nil if it changes none of the
+// files needed. A changed OS release counts as a change with no package
+// managers changed, so the OS release of the container is read. Returns false
+// when the image or the changes can't be read, then the whole container is
+// scanned.
+func getDockerImageChanges(cli *client.Client, container types.Container, packageManagers []package_manager.PackageManager, images *dockerImageScanner) (DockerImage, []package_manager.PackageManager, bool) {
+	if container.ImageID == "" {
+		return DockerImage{}, nil, false
+	}
+
+	changes, err := cli.ContainerDiff(context.Background(), container.ID)
+	if err != nil {
+		Log.Debugf("error getting changes of docker container %s: %v", container.ID, err)
+		return DockerImage{}, nil, false
+	}
 
+	image, ok := images.scan(container.ImageID)
+	if !ok {
+		return DockerImage{}, nil, false
+	}
+
+	var changed []package_manager.PackageManager
+	for _, packageManager := range packageManagers {
+		for _, change := range changes {
+			if changesFiles(change, packageManager.FilesNeeded()) {
+				Log.Debugf("docker container %s changes %s of its image for %s", container.ID, change.Path, packageManager.Id())
+				changed = append(changed, packageManager)
+				break
+			}
+		}
+	}
+
+	if changed == nil {
+		for _, change := range changes {
+			if changesFiles(change, package_manager.OsReleaseFiles) {
+				changed = []package_manager.PackageManager{}
+				break
+			}
+		}
+	}
+
+	return image, changed, true
+}
+
+// Get the packages of other package managers than the ones given, never nil
+// like the packages of scanFileSystem
+func packagesOfOthers(packages []package_manager.Package, packageManagers []package_manager.PackageManager) []package_manager.Package {
+	result := []package_manager.Package{}
+
+	for _, pkg := range packages {
+		other := true
 		for _, packageManager := range packageManagers {
-			files := packageManager.FilesNeeded()
+			if pkg.Manager == packageManager.Id() {
+				other = false
+				break
+			}
+		}
 
-			// Figure out if all files required by the package manager exist
-			allFilesPresent := true
-			fileMap := make(map[string]string)
-			for _, file := range files {
-				temp := TempFileName("df-")
+		if other {
+			result = append(result, pkg)
+		}
+	}
 
-				err = copyFileFromDockerContainer(cli, container, file, temp)
-				if err != nil {
-					allFilesPresent = false
-					break
-				}
+	return result
+}
+
+// Check if a change to the file system of a container changes files needed.
+// Changes are reported for the parent directories of every changed file as
+// well, so only deleting a parent directory counts.
+func changesFiles(change types.ContainerChange, files []package_manager.FileSpec) bool {
+	name := path.Clean("/" + change.Path)
 
-				fileMap[file] = temp
+	for _, file := range files {
+		if file.Matches(name) || wantsDirectory(file, name) {
+			return true
+		}
+
+		base := file.Path
+		if file.Kind == package_manager.GlobPattern {
+			base = package_manager.GlobBase(file.Path)
+		}
+
+		if change.Kind == dockerChangeDelete && strings.HasPrefix(path.Clean(base), name+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Get the files needed by the package managers and to read the OS release,
+// without duplicates
+func neededFiles(packageManagers []package_manager.PackageManager) []package_manager.FileSpec {
+	files := append([]package_manager.FileSpec(nil), package_manager.OsReleaseFiles...)
+	seen := make(map[package_manager.FileSpec]bool)
+
+	for _, file := range files {
+		seen[file] = true
+	}
+
+	for _, packageManager := range packageManagers {
+		for _, file := range packageManager.FilesNeeded() {
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
 			}
+		}
+	}
 
-			if !allFilesPresent {
+	return files
+}
+
+// Get the installed and the partially installed packages and the OS release
+// of a file system holding the files needed by the package managers, like a
+// copy of the files of a container. Problems are logged with the description
+// of the file system, problems with some files don't affect the other
+// packages.
+func scanFileSystem(fsys fs.FS, packageManagers []package_manager.PackageManager, description string) ([]package_manager.Package, []package_manager.Package, *package_manager.OsRelease) {
+	osRelease, err := package_manager.ReadOsRelease(fsys)
+	if err != nil {
+		Log.Debugf("getting os release of %s failed: %v", description, err)
+	}
+
+	var allPackages []package_manager.Package
+
+	for _, packageManager := range packageManagers {
+		// Figure out if what is required by the package manager exists
+		if !package_manager.Available(fsys, packageManager.FilesNeeded()) {
+			continue
+		}
+
+		Log.Debugf("Found package manager: %s for %s", packageManager.Id(), description)
+
+		packages, err := packageManager.Get(fsys)
+		if err != nil {
+			Log.Warnf("error getting packages of %s for %s: %v", packageManager.Id(), description, err)
+		}
+
+		allPackages = append(allPackages, packages...)
+	}
+
+	packages, partialPackages := package_manager.SplitInstalled(allPackages)
+
+	return packages, partialPackages, osRelease
+}
+
+// Get the metadata of a container. The state and restart policy are only
+// known after inspecting the container, the repository digests after
+// inspecting the image.
+func getDockerContainerMetadata(container types.Container, containerJSON *types.ContainerJSON, images *dockerImageScanner) DockerContainer {
+	dockerContainer := DockerContainer{
+		ID:      container.ID,
+		Image:   container.Image,
+		ImageID: container.ImageID,
+		Labels:  container.Labels,
+		State:   container.State,
+		Created: container.Created,
+		Command: container.Command,
+	}
+
+	for _, name := range container.Names {
+		dockerContainer.Names = append(dockerContainer.Names, strings.TrimPrefix(name, "/"))
+	}
+
+	if containerJSON != nil && containerJSON.ContainerJSONBase != nil {
+		if containerJSON.State != nil {
+			dockerContainer.State = containerJSON.State.Status
+		}
+
+		if containerJSON.HostConfig != nil {
+			restartPolicy := containerJSON.HostConfig.RestartPolicy
+			dockerContainer.RestartPolicy = restartPolicy.Name
+			if restartPolicy.IsOnFailure() && restartPolicy.MaximumRetryCount > 0 {
+				dockerContainer.RestartPolicy += fmt.Sprintf(":%d", restartPolicy.MaximumRetryCount)
+			}
+		}
+	}
+
+	if container.ImageID != "" {
+		dockerContainer.ImageDigests = dockerReferences(images.inspect(container.ImageID).RepoDigests)
+	}
+
+	return dockerContainer
+}
+
+// File specs copied from a docker container together, from a single tar
+// stream of the path
+type fileSpecGroup struct {
+	path  string
+	files []package_manager.FileSpec
+}
+
+// The largest file copied from a docker container
+const dockerMaxFileSize = 1 << 30
+
+// Group file specs by the path they are copied from: the file, the directory
+// or the base of the glob pattern. Specs below the path of another one are
+// copied with it, so no directory is copied more than once.
+func groupFileSpecs(files []package_manager.FileSpec) []fileSpecGroup {
+	sorted := append([]package_manager.FileSpec(nil), files...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return comparePaths(fileSpecPath(sorted[i]), fileSpecPath(sorted[j])) < 0
+	})
+
+	var groups []fileSpecGroup
+	for _, file := range sorted {
+		src := fileSpecPath(file)
+
+		if len(groups) > 0 {
+			group := &groups[len(groups)-1]
+			if src == group.path || strings.HasPrefix(src, strings.TrimSuffix(group.path, "/")+"/") {
+				group.files = append(group.files, file)
 				continue
 			}
+		}
+
+		groups = append(groups, fileSpecGroup{path: src, files: []package_manager.FileSpec{file}})
+	}
+
+	return groups
+}
+
+func fileSpecPath(file package_manager.FileSpec) string {
+	if file.Kind == package_manager.GlobPattern {
+		return package_manager.GlobBase(file.Path)
+	}
+
+	return path.Clean(file.Path)
+}
+
+// Compare paths element by element, so the paths below a directory directly
+// follow it
+func comparePaths(a string, b string) int {
+	aParts := splitOverlayPath(a)
+	bParts := splitOverlayPath(b)
+
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		if aParts[i] != bParts[i] {
+			return strings.Compare(aParts[i], bParts[i])
+		}
+	}
+
+	return len(aParts) - len(bParts)
+}
+
+// Copy the files of a group of file specs from a docker container into the
+// same location below root. A single tar stream of the path is read and only
+// the matching entries are extracted, up to the limits of the specs and
+// dockerMaxFileSize.
+func copyFromDockerContainer(client *client.Client, container types.Container, group fileSpecGroup, root string) error {
+	src := group.path
+
+	reader, _, err := client.CopyFromContainer(context.Background(), container.ID, src)
+	if err != nil {
+		return fmt.Errorf("could not find %s in docker container %s", src, container.ID)
+	}
+
+	defer reader.Close()
+
+	// Entries are named relative to the parent of the copied path
+	parent := path.Dir(path.Clean(src))
+	copied := make([]int, len(group.files))
+
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error copying %s from docker container %s: %v", src, container.ID, err)
+		}
+
+		name := path.Join(parent, path.Clean("/"+header.Name))
 
-			Log.Debugf("Found package manager: %s for docker container %s (%s)", packageManager.Id(), container.ID, container.Image)
+		location, ok := locateBelow(root, name)
+		if !ok {
+			continue
+		}
 
-			// Construct a slice with the temporary files in the right order
-			var tempFiles []string
-			for _, file := range files {
-				tempFiles = append(tempFiles, fileMap[file])
+		if header.Typeflag == tar.TypeDir {
+			for _, file := range group.files {
+				if wantsDirectory(file, name) {
+					err = os.MkdirAll(location, 0700)
+					break
+				}
 			}
+		} else if i := matchingFileSpec(group.files, name); i >= 0 {
+			file := group.files[i]
 
-			// Determine the packages
-			packages := packageManager.Get(tempFiles)
+			copied[i]++
+			if file.MaxFiles > 0 && copied[i] > file.MaxFiles {
+				if copied[i] == file.MaxFiles+1 {
+					Log.Debugf("copied the maximum of %d files of %s from docker container %s", file.MaxFiles, file.Path, container.ID)
+				}
+				continue
+			}
 
-			allPackages = append(allPackages, packages...)
+			switch header.Typeflag {
+			case tar.TypeReg:
+				if header.Size > dockerMaxFileSize {
+					Log.Debugf("not copying %s of %d bytes from docker container %s", name, header.Size, container.ID)
+					continue
+				}
 
-			// Delete temporary files
-			for _, temp := range fileMap {
-				err = os.Remove(temp)
-				if err != nil {
-					return nil, fmt.Errorf("error getting docker packages: %v", err)
+				err = writeFileFromTar(tarReader, location)
+			case tar.TypeLink:
+				// Files with several names are copied once, the others link
+				// to the earlier entry if that was copied
+				target, ok := locateBelow(root, path.Join(parent, path.Clean("/"+header.Linkname)))
+				if info, statErr := os.Lstat(target); ok && statErr == nil && info.Mode().IsRegular() {
+					err = linkFile(target, location)
 				}
+			case tar.TypeSymlink:
+				// Symbolic links are resolved within the copy, like the
+				// current link of flatpak applications
+				err = writeSymlink(header.Linkname, location)
 			}
 		}
+		if err != nil {
+			return fmt.Errorf("error copying %s from docker container %s: %v", name, container.ID, err)
+		}
+	}
+
+	return nil
+}
 
-		result[container.ID] = DockerContainer{
-			ID:       container.ID,
-			Image:    container.Image,
-			Packages: allPackages,
+// Get the index of the first file spec a file is part of, -1 if there is none
+func matchingFileSpec(files []package_manager.FileSpec, name string) int {
+	for i, file := range files {
+		if file.Matches(name) {
+			return i
 		}
 	}
 
-	return result, nil
+	return -1
 }
 
-// Copy a single file from a docker container
-func copyFileFromDockerContainer(client *client.Client, container types.Container, src string, dst string) error {
-	reader, _, err := client.CopyFromContainer(context.Background(), container.ID, src)
+// Check if a directory is created when copying the files of a file spec.
+// Directories are only created for directory copies, so an empty directory
+// still counts as present.
+func wantsDirectory(file package_manager.FileSpec, name string) bool {
+	if file.Kind != package_manager.Directory {
+		return false
+	}
+
+	return name == path.Clean(file.Path) || strings.HasPrefix(name, path.Clean(file.Path)+"/")
+}
+
+// Get the location of a path below root, unless a parent directory of it is a
+// symbolic link. Writing through it could write anywhere on the running
+// system, the entries of a well-formed tar file are never below a symbolic
+// link.
+func locateBelow(root string, name string) (string, bool) {
+	location := root
+
+	parts := splitOverlayPath(name)
+	for i := 0; i < len(parts)-1; i++ {
+		location = filepath.Join(location, parts[i])
 
+		info, err := os.Lstat(location)
+		if err != nil {
+			break
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return "", false
+		}
+	}
+
+	return package_manager.Resolve(root, name), true
+}
+
+// Write the current tar entry to a file, creating parent directories as
+// needed. A symbolic link of an earlier entry is replaced, not followed.
+func writeFileFromTar(tarReader *tar.Reader, dst string) error {
+	err := removeSymlink(dst)
 	if err != nil {
-		return fmt.Errorf("could not find the file %s in docker container %s", src, container.ID)
+		return err
 	}
 
-	defer reader.Close()
+	err = os.MkdirAll(filepath.Dir(dst), 0700)
+	if err != nil {
+		return err
+	}
 
 	writer, err := os.Create(dst)
-
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	defer writer.Close()
 
-	tarReader := tar.NewReader(reader)
-	_, err = tarReader.Next()
+	_, err = io.Copy(writer, tarReader)
 
+	return err
+}
+
+// Create a symbolic link, creating parent directories as needed. Like a file
+// written later, it replaces an earlier entry of the same name.
+func writeSymlink(target string, name string) error {
+	err := os.MkdirAll(filepath.Dir(name), 0700)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	buffer := make([]byte, 16384)
-	for {
-		read, readErr := tarReader.Read(buffer)
+	err = os.RemoveAll(name)
+	if err != nil {
+		return err
+	}
 
-		_, writeErr := writer.Write(buffer[0:read])
-		if writeErr != nil {
-			panic(writeErr)
-		}
+	return os.Symlink(target, name)
+}
 
-		if readErr == io.EOF {
-			break
-		}
-		if readErr != nil {
-			panic(err)
-		}
+func removeSymlink(name string) error {
+	if info, err := os.Lstat(name); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		return os.Remove(name)
 	}
+
 	return nil
 }
@@ -0,0 +1,552 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+
+	"q-jam.nl/c/c-client/package_manager"
+)
+
+// An image on the docker host. The repository digests are like
+// busybox@sha256:..., the dependencies are pairs of indexes in Packages, like
+// in Report, partially installed packages are in Partial.
+type DockerImage struct {
+	ID           string                     `json:"i"`
+	RepoTags     []string                   `json:"t,omitempty"`
+	RepoDigests  []string                   `json:"e,omitempty"`
+	OsRelease    *package_manager.OsRelease `json:"o,omitempty"`
+	Packages     []package_manager.Package  `json:"p"`
+	Dependencies [][2]int                   `json:"g,omitempty"`
+	Partial      []package_manager.Package  `json:"q,omitempty"`
+}
+
+// Whiteouts of the OCI image layout. A whiteout file hides the file of the
+// same name without prefix in lower layers, the opaque whiteout hides all
+// files in lower layers of the directory it is in.
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// Scans the images of a docker host, every image once however many
+// containers use it
+type dockerImageScanner struct {
+	cli             *client.Client
+	packageManagers []package_manager.PackageManager
+	files           []package_manager.FileSpec
+	inspected       map[string]types.ImageInspect
+	images          map[string]DockerImage
+	failed          map[string]bool
+}
+
+func newDockerImageScanner(cli *client.Client, packageManagers []package_manager.PackageManager) *dockerImageScanner {
+	return &dockerImageScanner{
+		cli:             cli,
+		packageManagers: packageManagers,
+		files:           neededFiles(packageManagers),
+		inspected:       make(map[string]types.ImageInspect),
+		images:          make(map[string]DockerImage),
+		failed:          make(map[string]bool),
+	}
+}
+
+// Get the tags and digests of an image, inspecting it once
+func (s *dockerImageScanner) inspect(imageID string) types.ImageInspect {
+	image, ok := s.inspected[imageID]
+	if !ok {
+		var err error
+		image, _, err = s.cli.ImageInspectWithRaw(context.Background(), imageID)
+		if err != nil {
+			Log.Debugf("error inspecting docker image %s: %v", imageID, err)
+		}
+
+		s.inspected[imageID] = image
+	}
+
+	return image
+}
+
+// Get the packages of an image, scanning it once. The files needed are read
+// from the directories of the storage driver when possible, otherwise from
+// the layers of the image as exported by docker save. Returns false when the
+// image can't be read.
+func (s *dockerImageScanner) scan(imageID string) (DockerImage, bool) {
+	if image, ok := s.images[imageID]; ok {
+		return image, true
+	}
+	if s.failed[imageID] {
+		return DockerImage{}, false
+	}
+
+	inspected := s.inspect(imageID)
+
+	fsys, ok := openDockerImageOverlay(inspected)
+	if !ok {
+		root := TempFileName("di-")
+		defer func() {
+			// Delete the temporary directory
+			err := os.RemoveAll(root)
+			if err != nil {
+				Log.Warnf("error deleting %s: %v", root, err)
+			}
+		}()
+
+		err := saveDockerImage(s.cli, imageID, s.files, root)
+		if err != nil {
+			Log.Warnf("error reading docker image %s: %v", imageID, err)
+			s.failed[imageID] = true
+			return DockerImage{}, false
+		}
+
+		fsys = package_manager.DirFS(root)
+	}
+
+	description := fmt.Sprintf("docker image %s", imageID)
+	packages, partialPackages, osRelease := scanFileSystem(fsys, s.packageManagers, description)
+
+	image := DockerImage{
+		ID:           imageID,
+		RepoTags:     dockerReferences(inspected.RepoTags),
+		RepoDigests:  dockerReferences(inspected.RepoDigests),
+		OsRelease:    osRelease,
+		Packages:     packages,
+		Dependencies: package_manager.NewDependencyGraph(packages).Edges(),
+		Partial:      partialPackages,
+	}
+	s.images[imageID] = image
+
+	return image, true
+}
+
+// Untagged images have the reference <none>:<none> or <none>@<none>
+func dockerReferences(references []string) []string {
+	var result []string
+
+	for _, reference := range references {
+		if !strings.HasPrefix(reference, "<none>") {
+			result = append(result, reference)
+		}
+	}
+
+	return result
+}
+
+// Export an image with docker save and extract the files needed into root
+func saveDockerImage(cli *client.Client, imageID string, files []package_manager.FileSpec, root string) error {
+	reader, err := cli.ImageSave(context.Background(), []string{imageID})
+	if err != nil {
+		return err
+	}
+
+	// The image is only exported again when needed, see extractImage. Every
+	// export is closed once extracted.
+	first := true
+	open := func() (io.ReadCloser, error) {
+		if first {
+			first = false
+			return reader, nil
+		}
+
+		return cli.ImageSave(context.Background(), []string{imageID})
+	}
+
+	_, err = extractDockerImage(open, files, root)
+
+	return err
+}
+
+// The manifest of an archive written by docker save, with the layers of each
+// image from the bottom up
+type dockerSaveManifest []dockerSaveImage
+
+type dockerSaveImage struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// The files needed of a layer, extracted into a directory of their own, the
+// files and directories of lower layers it hides and the targets of hard links
+// to files needed which were not extracted
+type imageLayer struct {
+	directory string
+	whiteouts []string
+	opaque    []string
+	links     []string
+}
+
+// How often the files of an image are extracted at most, see extractImage
+const maxImageExtractions = 3
+
+// Extract the files needed of an image into root with extract, which returns
+// the targets of hard links to files needed which were not extracted. Files
+// needed can be below symbolic links, like /var/lib/rpm linking to
+// /usr/lib/sysimage/rpm on Fedora, or hard links to files which are not needed
+// themselves. Both are only known once extracted, the image is extracted
+// again with the files they resolve to as well in that case.
+func extractImage(files []package_manager.FileSpec, root string, extract func(files []package_manager.FileSpec) ([]string, error)) error {
+	for extractions := 1; ; extractions++ {
+		links, err := extract(files)
+		if err != nil {
+			return err
+		}
+
+		more := linkedFiles(files, root, links)
+		if len(more) == 0 || extractions == maxImageExtractions {
+			return nil
+		}
+
+		err = os.RemoveAll(root)
+		if err != nil {
+			return err
+		}
+
+		files = append(files[:len(files):len(files)], more...)
+	}
+}
+
+// Get the files needed in addition to the files extracted into root: the
+// targets of hard links and what the files resolve to below symbolic links,
+// if the directory they would be in exists
+func linkedFiles(files []package_manager.FileSpec, root string, links []string) []package_manager.FileSpec {
+	var more []package_manager.FileSpec
+
+	add := func(file package_manager.FileSpec) {
+		for _, needed := range append(files[:len(files):len(files)], more...) {
+			if needed.Path == file.Path && needed.Kind == file.Kind {
+				return
+			}
+		}
+
+		more = append(more, file)
+	}
+
+	for _, link := range links {
+		add(package_manager.FileSpec{Path: link, Optional: true})
+	}
+
+	fsys := package_manager.DirFS(root)
+
+	for _, file := range files {
+		base := path.Clean(file.Path)
+		if file.Kind == package_manager.GlobPattern {
+			base = package_manager.GlobBase(file.Path)
+		}
+
+		resolved := package_manager.ResolveSymlinks(fsys, base)
+		if resolved == base {
+			continue
+		}
+
+		directory := resolved
+		if file.Kind == package_manager.RegularFile {
+			directory = path.Dir(resolved)
+		}
+
+		if !package_manager.Present(fsys, package_manager.FileSpec{Path: directory, Kind: package_manager.Directory}) {
+			continue
+		}
+
+		file.Path = resolved + strings.TrimPrefix(path.Clean(file.Path), base)
+		add(file)
+	}
+
+	return more
+}
+
+// Extract the files needed from an archive written by docker save into root,
+// see extractImage, and get the image. The archive is opened again for every
+// extraction.
+func extractDockerImage(open func() (io.ReadCloser, error), files []package_manager.FileSpec, root string) (dockerSaveImage, error) {
+	var image dockerSaveImage
+
+	err := extractImage(files, root, func(files []package_manager.FileSpec) ([]string, error) {
+		reader, err := open()
+		if err != nil {
+			return nil, err
+		}
+
+		defer reader.Close()
+
+		var links []string
+		image, links, err = extractDockerSave(reader, files, root)
+
+		return links, err
+	})
+
+	return image, err
+}
+
+// Extract the files needed from an archive written by docker save into root,
+// applying the layers of the first image in it in order, and get that image
+// and the targets of hard links not extracted. The manifest with the order
+// comes last, so the layers are extracted next to root first.
+func extractDockerSave(reader io.Reader, files []package_manager.FileSpec, root string) (dockerSaveImage, []string, error) {
+	layersDirectory := root + "-layers"
+	defer os.RemoveAll(layersDirectory)
+
+	var manifest dockerSaveManifest
+	layers := make(map[string]*imageLayer)
+	links := make(map[string]string)
+
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return dockerSaveImage{}, nil, err
+		}
+
+		name := path.Clean(header.Name)
+
+		switch {
+		case name == "manifest.json":
+			err = json.NewDecoder(tarReader).Decode(&manifest)
+			if err != nil {
+				return dockerSaveImage{}, nil, fmt.Errorf("invalid manifest: %v", err)
+			}
+		case header.Typeflag == tar.TypeSymlink:
+			// Layers shared by several images are stored once, the others
+			// link to it
+			links[name] = path.Join(path.Dir(name), header.Linkname)
+		case header.Typeflag == tar.TypeReg && (path.Base(name) == "layer.tar" || strings.HasPrefix(name, "blobs/")):
+			// The blobs of the OCI layout are configurations and manifests
+			// as well, which are not tar files
+			layer, err := extractImageLayer(tarReader, files, filepath.Join(layersDirectory, strconv.Itoa(len(layers))))
+			if err != nil {
+				return dockerSaveImage{}, nil, fmt.Errorf("error reading layer %s: %v", name, err)
+			}
+			if layer != nil {
+				layers[name] = layer
+			}
+		}
+	}
+
+	if len(manifest) == 0 {
+		return dockerSaveImage{}, nil, fmt.Errorf("no image in manifest")
+	}
+
+	var hardLinks []string
+
+	for _, name := range manifest[0].Layers {
+		name = path.Clean(name)
+		for i := 0; i < len(links) && links[name] != ""; i++ {
+			name = links[name]
+		}
+
+		layer, ok := layers[name]
+		if !ok {
+			return dockerSaveImage{}, nil, fmt.Errorf("layer %s missing", name)
+		}
+
+		err := applyImageLayer(layer, root)
+		if err != nil {
+			return dockerSaveImage{}, nil, fmt.Errorf("error applying layer %s: %v", name, err)
+		}
+
+		hardLinks = append(hardLinks, layer.links...)
+	}
+
+	return manifest[0], hardLinks, nil
+}
+
+// Extract the files needed from a layer, which may be compressed, and collect
+// its whiteouts. Returns nil if the layer is not a tar file.
+func extractImageLayer(reader io.Reader, files []package_manager.FileSpec, directory string) (*imageLayer, error) {
+	layerReader, err := decompressedReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	layer := &imageLayer{directory: directory}
+
+	tarReader := tar.NewReader(layerReader)
+	for first := true; ; first = false {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if first {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		name := path.Join("/", header.Name)
+		base := path.Base(name)
+
+		location, ok := locateBelow(directory, name)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case base == whiteoutOpaque:
+			layer.opaque = append(layer.opaque, path.Dir(name))
+		case strings.HasPrefix(base, whiteoutPrefix):
+			layer.whiteouts = append(layer.whiteouts, path.Join(path.Dir(name), strings.TrimPrefix(base, whiteoutPrefix)))
+		case header.Typeflag == tar.TypeDir:
+			// Directories and symbolic links are extracted whatever the
+			// files needed, they show where files needed are below symbolic
+			// links, see extractImage
+			err = os.MkdirAll(location, 0700)
+		case header.Typeflag == tar.TypeSymlink:
+			err = writeSymlink(header.Linkname, location)
+		case !matchesAny(files, name):
+		case header.Typeflag == tar.TypeLink:
+			// A hard link refers to an earlier entry of the layer, which is
+			// only extracted when it is needed as well. Until it is, the
+			// link still hides the file of lower layers.
+			target := path.Join("/", header.Linkname)
+			targetLocation, ok := locateBelow(directory, target)
+			if info, err := os.Lstat(targetLocation); ok && err == nil && info.Mode().IsRegular() {
+				err = linkFile(targetLocation, location)
+			} else {
+				layer.whiteouts = append(layer.whiteouts, name)
+				layer.links = append(layer.links, target)
+			}
+		case header.Typeflag == tar.TypeReg:
+			err = writeFileFromTar(tarReader, location)
+		default:
+			// Anything else, like a device, still hides the file of lower
+			// layers
+			layer.whiteouts = append(layer.whiteouts, name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return layer, nil
+}
+
+// Create a hard link, creating parent directories as needed. Like a file
+// written later, it replaces an earlier entry of the same name.
+func linkFile(target string, name string) error {
+	err := os.MkdirAll(filepath.Dir(name), 0700)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return os.Link(target, name)
+}
+
+// Get a reader decompressing gzip compressed data, other data is read as is
+func decompressedReader(reader io.Reader) (io.Reader, error) {
+	bufferedReader := bufio.NewReader(reader)
+
+	if magic, err := bufferedReader.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(bufferedReader)
+	}
+
+	return bufferedReader, nil
+}
+
+// Apply a layer on the files of the layers below it in root. Whiteouts only
+// apply to lower layers, so they are applied before moving the files of the
+// layer into root.
+func applyImageLayer(layer *imageLayer, root string) error {
+	// Lower layers can have symbolic links where the layer has directories,
+	// those are replaced by the directories instead of followed
+	for _, directory := range layer.opaque {
+		location, ok := locateBelow(root, directory)
+		if !ok {
+			continue
+		}
+
+		err := removeSymlink(location)
+		if err != nil {
+			return err
+		}
+
+		entries, _ := os.ReadDir(location)
+		for _, entry := range entries {
+			err := os.RemoveAll(filepath.Join(location, entry.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, file := range layer.whiteouts {
+		location, ok := locateBelow(root, file)
+		if !ok {
+			continue
+		}
+
+		err := os.RemoveAll(location)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := os.Stat(layer.directory); os.IsNotExist(err) {
+		return nil
+	}
+
+	return filepath.WalkDir(layer.directory, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relative, err := filepath.Rel(layer.directory, file)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(root, relative)
+
+		// A file may replace a directory of a lower layer and the other way
+		// around
+		info, err := os.Lstat(target)
+		if err == nil && info.IsDir() != entry.IsDir() {
+			err = os.RemoveAll(target)
+			if err != nil {
+				return err
+			}
+		}
+
+		if entry.IsDir() {
+			return os.MkdirAll(target, 0700)
+		}
+
+		return os.Rename(file, target)
+	})
+}
+
+// Check if a regular file is part of any of the file specs
+func matchesAny(files []package_manager.FileSpec, name string) bool {
+	for _, file := range files {
+		if file.Matches(name) {
+			return true
+		}
+	}
+
+	return false
+}
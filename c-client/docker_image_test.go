@@ -0,0 +1,243 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"q-jam.nl/c/c-client/package_manager"
+)
+
+// An entry of a tar file, a hard link when link is set, a symbolic link when
+// symlink is set and a directory when the name ends with a slash
+type testTarEntry struct {
+	name    string
+	data    string
+	link    string
+	symlink string
+}
+
+func writeTestTar(t *testing.T, entries []testTarEntry) []byte {
+	var buffer bytes.Buffer
+
+	writer := tar.NewWriter(&buffer)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(entry.data))}
+		if entry.link != "" {
+			header.Typeflag = tar.TypeLink
+			header.Linkname = entry.link
+			header.Size = 0
+		}
+		if entry.symlink != "" {
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = entry.symlink
+			header.Size = 0
+		}
+		if strings.HasSuffix(entry.name, "/") {
+			header.Typeflag = tar.TypeDir
+		}
+
+		err := writer.WriteHeader(header)
+		if err == nil && header.Typeflag == tar.TypeReg {
+			_, err = writer.Write([]byte(entry.data))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+// The layers of an image written by docker save are applied in the order of
+// the manifest, with their whiteouts, opaque directories and links. Symbolic
+// links are kept, but never followed when applying a layer. The archive is
+// read again for the files needed below symbolic links and the targets of
+// hard links.
+func TestExtractDockerImage(t *testing.T) {
+	outside := t.TempDir()
+	err := os.WriteFile(filepath.Join(outside, "victim"), []byte("victim"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lower := writeTestTar(t, []testTarEntry{
+		{name: "etc/os-release", data: "ID=lower\n"},
+		{name: "lib/apk/db/installed", data: "P:musl\n"},
+		{name: "var/lib/dpkg/status", data: "Package: bash\n"},
+		{name: "var/lib/dpkg/info/bash.list", data: "/bin/bash\n"},
+		{name: "var/lib/dpkg/info/dash.list", data: "/bin/dash\n"},
+		{name: "usr/lib/sysimage/rpm/"},
+		{name: "usr/lib/sysimage/rpm/rpmdb.sqlite", data: "rpmdb"},
+		{name: "var/lib/rpm", symlink: "../../usr/lib/sysimage/rpm"},
+		{name: "lib", symlink: "usr/lib"},
+		{name: "var/lib/flatpak/app/org.test.App/current", symlink: "x86_64/stable"},
+		{name: "var/lib/flatpak/app/org.test.App/x86_64/stable/active", symlink: "0123"},
+		{name: "var/lib/flatpak/app/org.test.App/x86_64/stable/0123/metadata", data: "[Application]\n"},
+		{name: "var/lib/flatpak/runtime/org.test.Evil/current", symlink: outside},
+	})
+
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	_, err = gzipWriter.Write(lower)
+	if err == nil {
+		err = gzipWriter.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upper := writeTestTar(t, []testTarEntry{
+		{name: "lib/apk/db/.wh.installed"},
+		{name: "var/lib/dpkg/info/.wh..wh..opq"},
+		{name: "var/lib/dpkg/info/zsh.list", data: "/bin/zsh\n"},
+		{name: "usr/lib/os-release", data: "ID=upper\n"},
+		{name: "etc/os-release", link: "usr/lib/os-release"},
+		{name: "usr/bin/not-needed", data: "not needed"},
+		{name: "var/lib/dpkg/status", link: "usr/bin/not-needed"},
+		{name: "var/lib/flatpak/runtime/org.test.Evil/current/.wh.victim"},
+	})
+
+	manifest, err := json.Marshal(dockerSaveManifest{{
+		Config:   "0123.json",
+		RepoTags: []string{"test:latest"},
+		Layers:   []string{"lower/layer.tar", "upper/layer.tar"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The upper layer comes first in the archive
+	archive := writeTestTar(t, []testTarEntry{
+		{name: "upper/layer.tar", data: string(upper)},
+		{name: "lower/layer.tar", data: compressed.String()},
+		{name: "0123.json", data: "{}"},
+		{name: "manifest.json", data: string(manifest)},
+	})
+
+	files := []package_manager.FileSpec{
+		{Path: "/etc/os-release", Optional: true},
+		{Path: "/usr/lib/os-release", Optional: true},
+		{Path: "/lib/apk/db/installed", Optional: true},
+		{Path: "/var/lib/dpkg/status", Optional: true},
+		{Path: "/var/lib/dpkg/info", Kind: package_manager.Directory, Optional: true},
+		{Path: "/var/lib/rpm/rpmdb.sqlite", Optional: true},
+		{Path: "/lib/rpm/rpmdb.sqlite", Optional: true},
+	}
+	files = append(files, package_manager.FlatpakPackageManagerImpl{}.FilesNeeded()...)
+
+	root := filepath.Join(t.TempDir(), "root")
+
+	opened := 0
+	open := func() (io.ReadCloser, error) {
+		opened++
+		return io.NopCloser(bytes.NewReader(archive)), nil
+	}
+
+	image, err := extractDockerImage(open, files, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if image.Config != "0123.json" {
+		t.Errorf("image %s, expected 0123.json", image.Config)
+	}
+	if opened != 2 {
+		t.Errorf("archive read %d times, expected 2", opened)
+	}
+
+	// The file a hard link needed links to is extracted as well, the files
+	// below /lib aren't as there are none
+	expected := map[string]string{
+		"var/lib/dpkg/status":                      "not needed",
+		"usr/bin/not-needed":                       "not needed",
+		"var/lib/rpm":                              "-> ../../usr/lib/sysimage/rpm",
+		"usr/lib/sysimage/rpm/rpmdb.sqlite":        "rpmdb",
+		"lib":                                      "-> usr/lib",
+		"etc/os-release":                           "ID=upper\n",
+		"usr/lib/os-release":                       "ID=upper\n",
+		"var/lib/dpkg/info/zsh.list":               "/bin/zsh\n",
+		"var/lib/flatpak/app/org.test.App/current": "-> x86_64/stable",
+		"var/lib/flatpak/app/org.test.App/x86_64/stable/active":        "-> 0123",
+		"var/lib/flatpak/app/org.test.App/x86_64/stable/0123/metadata": "[Application]\n",
+		"var/lib/flatpak/runtime/org.test.Evil/current":                "-> " + outside,
+	}
+
+	found := make(map[string]string)
+	err = filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		name, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(file)
+			found[filepath.ToSlash(name)] = "-> " + target
+
+			return err
+		}
+
+		data, err := os.ReadFile(file)
+		found[filepath.ToSlash(name)] = string(data)
+
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(found) != len(expected) {
+		var names []string
+		for name := range found {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		t.Errorf("files %v, expected %d files", names, len(expected))
+	}
+
+	for name, data := range expected {
+		if found[name] != data {
+			t.Errorf("%s: %q, expected %q", name, found[name], data)
+		}
+	}
+
+	data, err := fs.ReadFile(package_manager.DirFS(root), "var/lib/rpm/rpmdb.sqlite")
+	if string(data) != "rpmdb" {
+		t.Errorf("var/lib/rpm/rpmdb.sqlite: %q, %v, expected %q", data, err, "rpmdb")
+	}
+
+	if _, err := os.Stat(filepath.Join(outside, "victim")); err != nil {
+		t.Errorf("whiteout applied outside of root: %v", err)
+	}
+
+	packages, err := package_manager.FlatpakPackageManagerImpl{}.Get(package_manager.DirFS(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packages) != 1 || packages[0].Name != "org.test.App" || packages[0].Version != "stable" {
+		t.Errorf("flatpak packages %v, expected org.test.App stable", packages)
+	}
+}
@@ -0,0 +1,279 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// The most symbolic links followed resolving a path, like Linux
+const maxOverlaySymlinks = 40
+
+var errTooManySymlinks = errors.New("too many levels of symbolic links")
+
+// Get the file system of a container stored by the overlay2 storage driver,
+// read directly from the directories of the driver. That only works when
+// running as root on the docker host, otherwise false is returned and the
+// files have to be copied through the API. The merged directory is only
+// mounted while the container runs, for other containers the layers are
+// merged while reading.
+func openDockerOverlay(containerJSON *types.ContainerJSON) (fs.FS, bool) {
+	if containerJSON == nil || containerJSON.ContainerJSONBase == nil {
+		return nil, false
+	}
+
+	running := containerJSON.State != nil && containerJSON.State.Running
+	description := fmt.Sprintf("docker container %s", containerJSON.ID)
+
+	return openOverlayLayers(containerJSON.GraphDriver, running, description)
+}
+
+// Get the file system of an image stored by the overlay2 storage driver, its
+// layers without the writable layer of any container. Like for containers
+// that only works when running as root on the docker host, otherwise false is
+// returned and the image has to be exported through the API.
+func openDockerImageOverlay(image types.ImageInspect) (fs.FS, bool) {
+	if image.ID == "" {
+		return nil, false
+	}
+
+	description := fmt.Sprintf("docker image %s", image.ID)
+
+	return openOverlayLayers(image.GraphDriver, false, description)
+}
+
+// Get the file system of the layers of the overlay2 storage driver, the
+// merged directory when it is mounted, otherwise the upper directory and the
+// lower directories below it
+func openOverlayLayers(driver types.GraphDriverData, mounted bool, description string) (fs.FS, bool) {
+	if driver.Name != "overlay2" {
+		return nil, false
+	}
+
+	data := driver.Data
+
+	var layers []string
+	if mounted && data["MergedDir"] != "" {
+		layers = []string{data["MergedDir"]}
+	} else if data["UpperDir"] != "" {
+		layers = append([]string{data["UpperDir"]}, filepath.SplitList(data["LowerDir"])...)
+	}
+
+	if len(layers) == 0 {
+		return nil, false
+	}
+
+	for _, layer := range layers {
+		if _, err := os.ReadDir(layer); err != nil {
+			Log.Debugf("can't read %s directly: %v", description, err)
+			return nil, false
+		}
+	}
+
+	return overlayFS{layers: layers}, true
+}
+
+// The file system of overlay layers, from the top down. A file in a layer
+// hides the files of the same name in lower layers, directories are merged
+// unless opaque. Symbolic links are resolved within the file system, as they
+// would be in the container, instead of on the host.
+type overlayFS struct {
+	layers []string
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	locations, err := o.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(locations[0])
+}
+
+func (o overlayFS) Stat(name string) (fs.FileInfo, error) {
+	locations, err := o.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Stat(locations[0])
+}
+
+func (o overlayFS) Lstat(name string) (fs.FileInfo, error) {
+	locations, err := o.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Lstat(locations[0])
+}
+
+func (o overlayFS) ReadLink(name string) (string, error) {
+	locations, err := o.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+
+	return os.Readlink(locations[0])
+}
+
+// Read a directory, merging the entries of all layers it is in
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	locations, err := o.lookup("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []fs.DirEntry
+	seen := make(map[string]bool)
+
+	for _, location := range locations {
+		layerEntries, err := os.ReadDir(location)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range layerEntries {
+			if seen[entry.Name()] {
+				continue
+			}
+			seen[entry.Name()] = true
+
+			info, err := entry.Info()
+			if err != nil || isOverlayWhiteout(info) {
+				continue
+			}
+
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries, nil
+}
+
+// Get the locations of a file in the layers, from the top down. Only
+// directories can be in several layers. Symbolic links in parent directories
+// are followed, a final symbolic link only when follow is set.
+func (o overlayFS) lookup(op string, name string, follow bool) ([]string, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	parts := splitOverlayPath(name)
+
+	for links := 0; links <= maxOverlaySymlinks; links++ {
+		locations, symlink := o.locate(parts, follow)
+		if symlink < 0 {
+			if len(locations) == 0 {
+				return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+			}
+
+			return locations, nil
+		}
+
+		target, err := os.Readlink(locations[0])
+		if err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+
+		// Absolute targets are relative to the root of the container, so
+		// are relative targets going up beyond it
+		if !path.IsAbs(target) {
+			target = path.Join(append(append([]string{"/"}, parts[:symlink]...), target)...)
+		}
+		parts = append(splitOverlayPath(target), parts[symlink+1:]...)
+	}
+
+	return nil, &fs.PathError{Op: op, Path: name, Err: errTooManySymlinks}
+}
+
+// Get the locations of a path in the layers it is visible in, or the location
+// and the index in parts of the first symbolic link to follow
+func (o overlayFS) locate(parts []string, follow bool) ([]string, int) {
+	var locations []string
+
+	for _, layer := range o.layers {
+		location := layer
+		present := true
+		// Whether this layer hides the path in lower layers
+		hidden := false
+
+		for i, part := range parts {
+			location = filepath.Join(location, part)
+			last := i == len(parts)-1
+
+			info, err := os.Lstat(location)
+			if err != nil {
+				present = false
+				break
+			}
+
+			if isOverlayWhiteout(info) {
+				present = false
+				hidden = true
+				break
+			}
+
+			if info.Mode()&fs.ModeSymlink != 0 && (!last || follow) {
+				// Only followed when not hidden by a directory of an upper
+				// layer
+				if len(locations) == 0 {
+					return []string{location}, i
+				}
+
+				present = false
+				hidden = true
+				break
+			}
+
+			if !info.IsDir() {
+				// A file hides everything of lower layers, and can't have
+				// anything below it
+				hidden = true
+				if !last || len(locations) > 0 {
+					present = false
+				}
+				break
+			}
+
+			if isOverlayOpaque(location) {
+				hidden = true
+			}
+		}
+
+		if present {
+			locations = append(locations, location)
+		}
+
+		if hidden {
+			break
+		}
+	}
+
+	return locations, -1
+}
+
+func splitOverlayPath(name string) []string {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return nil
+	}
+
+	return strings.Split(name, "/")
+}
@@ -0,0 +1,33 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package main
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// Check if a file of an overlay layer is a whiteout, a character device with
+// device number 0/0 hiding the file of the same name in lower layers
+func isOverlayWhiteout(info fs.FileInfo) bool {
+	if info.Mode()&fs.ModeCharDevice == 0 {
+		return false
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+
+	return ok && stat.Rdev == 0
+}
+
+// Check if a directory of an overlay layer is opaque, hiding the directories
+// of the same name in lower layers
+func isOverlayOpaque(location string) bool {
+	value := make([]byte, 1)
+
+	size, err := syscall.Getxattr(location, "trusted.overlay.opaque", value)
+
+	return err == nil && size == 1 && value[0] == 'y'
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+// +build !linux
+
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package main
+
+import (
+	"io/fs"
+)
+
+// The overlay storage driver only exists on Linux, where whiteouts are
+// character devices
+func isOverlayWhiteout(info fs.FileInfo) bool {
+	return info.Mode()&fs.ModeCharDevice != 0
+}
+
+// Opaque directories are marked with an extended attribute, which can't be
+// read here
+func isOverlayOpaque(location string) bool {
+	return false
+}
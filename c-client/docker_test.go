@@ -0,0 +1,39 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"q-jam.nl/c/c-client/package_manager"
+)
+
+// Every directory is copied once, with the specs of the files below it
+func TestGroupFileSpecs(t *testing.T) {
+	files := []package_manager.FileSpec{
+		{Path: "/usr/lib/python*/*-packages/*.dist-info/METADATA", Kind: package_manager.GlobPattern},
+		{Path: "/usr/lib-x/status"},
+		{Path: "/opt/bin/*", Kind: package_manager.GlobPattern},
+		{Path: "/usr/lib/os-release"},
+		{Path: "/usr/lib64/python*/*-packages/*.dist-info/METADATA", Kind: package_manager.GlobPattern},
+		{Path: "/opt/*", Kind: package_manager.GlobPattern},
+		{Path: "/etc/os-release"},
+	}
+
+	expected := []fileSpecGroup{
+		{path: "/etc/os-release", files: []package_manager.FileSpec{files[6]}},
+		{path: "/opt", files: []package_manager.FileSpec{files[5], files[2]}},
+		{path: "/usr/lib", files: []package_manager.FileSpec{files[0], files[3]}},
+		{path: "/usr/lib-x/status", files: []package_manager.FileSpec{files[1]}},
+		{path: "/usr/lib64", files: []package_manager.FileSpec{files[4]}},
+	}
+
+	groups := groupFileSpecs(files)
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("groups %v, expected %v", groups, expected)
+	}
+}
@@ -0,0 +1,266 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"q-jam.nl/c/c-client/package_manager"
+)
+
+// Media types of the OCI image layout, the docker ones are used by images
+// built by docker
+const (
+	ociImageIndex        = "application/vnd.oci.image.index.v1+json"
+	dockerManifestList   = "application/vnd.docker.distribution.manifest.list.v2+json"
+	ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+)
+
+// The index.json of an OCI image layout and the manifests it refers to, only
+// the fields needed
+type ociIndex struct {
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+type ociManifest struct {
+	Config ociDescriptor   `json:"config"`
+	Layers []ociDescriptor `json:"layers"`
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+	} `json:"platform,omitempty"`
+}
+
+// Get the packages of an image without a docker daemon, from an OCI image
+// layout directory or an archive written by docker save, which may be gzip
+// compressed. The layers are applied like docker does, only for the files
+// needed. The ID of the image is the digest of its configuration.
+func ScanImageFile(packageManagers []package_manager.PackageManager, location string) (DockerImage, error) {
+	info, err := os.Stat(location)
+	if err != nil {
+		return DockerImage{}, fmt.Errorf("error reading image %s: %v", location, err)
+	}
+
+	files := neededFiles(packageManagers)
+
+	root := TempFileName("df-")
+	defer func() {
+		// Delete the temporary directory
+		err := os.RemoveAll(root)
+		if err != nil {
+			Log.Warnf("error deleting %s: %v", root, err)
+		}
+	}()
+
+	var image DockerImage
+	if info.IsDir() {
+		image, err = extractOciLayout(location, files, root)
+	} else {
+		image, err = extractDockerImageFile(location, files, root)
+	}
+	if err != nil {
+		return DockerImage{}, fmt.Errorf("error reading image %s: %v", location, err)
+	}
+
+	description := fmt.Sprintf("image %s", location)
+	image.Packages, image.Partial, image.OsRelease = scanFileSystem(package_manager.DirFS(root), packageManagers, description)
+	image.Dependencies = package_manager.NewDependencyGraph(image.Packages).Edges()
+
+	return image, nil
+}
+
+// Extract the files needed from an archive written by docker save into root
+func extractDockerImageFile(location string, files []package_manager.FileSpec, root string) (DockerImage, error) {
+	open := func() (io.ReadCloser, error) {
+		file, err := os.Open(location)
+		if err != nil {
+			return nil, err
+		}
+
+		reader, err := decompressedReader(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		return struct {
+			io.Reader
+			io.Closer
+		}{reader, file}, nil
+	}
+
+	saved, err := extractDockerImage(open, files, root)
+	if err != nil {
+		return DockerImage{}, err
+	}
+
+	// The configuration is named after its digest, like <hex>.json or
+	// blobs/sha256/<hex> in the OCI layout of recent docker versions
+	id := strings.TrimSuffix(path.Base(saved.Config), ".json")
+	if dir := path.Dir(saved.Config); strings.HasPrefix(dir, "blobs/") {
+		id = path.Base(dir) + ":" + id
+	} else {
+		id = "sha256:" + id
+	}
+
+	return DockerImage{ID: id, RepoTags: saved.RepoTags}, nil
+}
+
+// Extract the files needed from an OCI image layout directory into root,
+// applying the layers of the image in it. Of an index of several images, the
+// one for this architecture is used.
+func extractOciLayout(directory string, files []package_manager.FileSpec, root string) (DockerImage, error) {
+	var index ociIndex
+	err := readOciJSON(filepath.Join(directory, "index.json"), &index)
+	if err != nil {
+		return DockerImage{}, err
+	}
+
+	var tags []string
+	var descriptor ociDescriptor
+
+	// Indexes can be nested, like an image built for several platforms
+	for {
+		if len(index.Manifests) == 0 {
+			return DockerImage{}, fmt.Errorf("no image in index")
+		}
+
+		descriptor = selectOciManifest(index.Manifests)
+		if tag := descriptor.Annotations[ociRefNameAnnotation]; tag != "" {
+			tags = append(tags, tag)
+		}
+
+		if descriptor.MediaType != ociImageIndex && descriptor.MediaType != dockerManifestList {
+			break
+		}
+
+		blob, err := ociBlob(directory, descriptor.Digest)
+		if err != nil {
+			return DockerImage{}, err
+		}
+
+		index = ociIndex{}
+		err = readOciJSON(blob, &index)
+		if err != nil {
+			return DockerImage{}, err
+		}
+	}
+
+	blob, err := ociBlob(directory, descriptor.Digest)
+	if err != nil {
+		return DockerImage{}, err
+	}
+
+	var manifest ociManifest
+	err = readOciJSON(blob, &manifest)
+	if err != nil {
+		return DockerImage{}, err
+	}
+
+	err = extractImage(files, root, func(files []package_manager.FileSpec) ([]string, error) {
+		layersDirectory := root + "-layers"
+		defer os.RemoveAll(layersDirectory)
+
+		var links []string
+
+		for i, layerDescriptor := range manifest.Layers {
+			blob, err := ociBlob(directory, layerDescriptor.Digest)
+			if err != nil {
+				return nil, err
+			}
+
+			layer, err := extractOciLayer(blob, files, filepath.Join(layersDirectory, strconv.Itoa(i)))
+			if err != nil {
+				return nil, fmt.Errorf("error reading layer %s: %v", layerDescriptor.Digest, err)
+			}
+
+			err = applyImageLayer(layer, root)
+			if err != nil {
+				return nil, fmt.Errorf("error applying layer %s: %v", layerDescriptor.Digest, err)
+			}
+
+			links = append(links, layer.links...)
+		}
+
+		return links, nil
+	})
+	if err != nil {
+		return DockerImage{}, err
+	}
+
+	return DockerImage{ID: manifest.Config.Digest, RepoTags: tags}, nil
+}
+
+// Extract the files needed from a layer of an OCI image layout
+func extractOciLayer(blob string, files []package_manager.FileSpec, directory string) (*imageLayer, error) {
+	file, err := os.Open(blob)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	layer, err := extractImageLayer(file, files, directory)
+	if err != nil {
+		return nil, err
+	}
+	if layer == nil {
+		return nil, fmt.Errorf("not a tar file or gzip compressed tar file")
+	}
+
+	return layer, nil
+}
+
+// Select the manifest for the platform of this system, or the first one if
+// there is none for it
+func selectOciManifest(manifests []ociDescriptor) ociDescriptor {
+	for _, manifest := range manifests {
+		if manifest.Platform != nil && manifest.Platform.OS == "linux" && manifest.Platform.Architecture == runtime.GOARCH {
+			return manifest
+		}
+	}
+
+	return manifests[0]
+}
+
+// Get the location of a blob of an OCI image layout, digests are like
+// sha256:<hex>
+func ociBlob(directory string, digest string) (string, error) {
+	split := strings.SplitN(digest, ":", 2)
+	if len(split) != 2 || split[0] == "" || split[1] == "" || strings.ContainsAny(digest, `/\`) {
+		return "", fmt.Errorf("invalid digest %s", digest)
+	}
+
+	return filepath.Join(directory, "blobs", split[0], split[1]), nil
+}
+
+func readOciJSON(location string, value interface{}) error {
+	data, err := os.ReadFile(location)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(data, value)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", filepath.Base(location), err)
+	}
+
+	return nil
+}
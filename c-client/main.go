@@ -25,18 +25,41 @@ import (
 
 var Log = logrus.New()
 
+// The dependencies are pairs of indexes in Packages of a package and the
+// package it depends on. The images are keyed by image ID, they are the images
+// whose packages containers share and with -docker-images all images.
+// Partially installed packages and removed packages with configuration files
+// left, see Package.Installed, are in Partial instead of Packages.
 type Report struct {
-	UUID     string                    `json:"u"`
-	Hostname string                    `json:"h"`
-	Time     int64                     `json:"t"`
-	Packages []package_manager.Package `json:"p"`
-	Docker   []DockerContainer         `json:"d"`
+	UUID         string                        `json:"u"`
+	Hostname     string                        `json:"h"`
+	OsRelease    *package_manager.OsRelease    `json:"o,omitempty"`
+	Time         int64                         `json:"t"`
+	Packages     []package_manager.Package     `json:"p"`
+	Dependencies [][2]int                      `json:"g,omitempty"`
+	Docker       []DockerContainer             `json:"d"`
+	Images       map[string]DockerImage        `json:"i,omitempty"`
+	Verification *package_manager.Verification `json:"y,omitempty"`
+	Partial      []package_manager.Package     `json:"q,omitempty"`
 }
 
 type Configuration struct {
-	APIEndpoint string
-	APIKey      string
-	LogLevel    logrus.Level
+	APIEndpoint     string
+	APIKey          string
+	LogLevel        logrus.Level
+	VirtualenvRoots []string
+	NodeRoots       []string
+	NodeMaxDepth    int
+	NodeMaxFiles    int
+	GoBinaryPaths   []string
+	JavaPaths       []string
+	GemPaths        []string
+	RustBinaryPaths []string
+	PackageManagers []string
+	Verify          bool
+	DockerAll       bool
+	DockerImages    bool
+	Image           string
 }
 
 func main() {
@@ -46,17 +69,67 @@ func main() {
 		os.Exit(-1)
 	}
 
-	configurationAsJson, err := json.Marshal(configuration)
-	fmt.Println(string(configurationAsJson))
+	// When scanning an image file the output is the image only
+	if configuration.Image == "" {
+		configurationAsJson, _ := json.Marshal(configuration)
+		fmt.Println(string(configurationAsJson))
+	}
 
 	Log.Level = logrus.DebugLevel
 
-	var packageManagers = []package_manager.PackageManager{
+	var allPackageManagers = []package_manager.PackageManager{
 		package_manager.ApkPackageManagerImpl{},
 		package_manager.DebPackageManagerImpl{},
+		package_manager.RpmPackageManagerImpl{},
+		package_manager.PacmanPackageManagerImpl{},
+		package_manager.PipPackageManagerImpl{VirtualenvRoots: configuration.VirtualenvRoots},
+		package_manager.NpmPackageManagerImpl{
+			Roots:    configuration.NodeRoots,
+			MaxDepth: configuration.NodeMaxDepth,
+			MaxFiles: configuration.NodeMaxFiles,
+		},
+		package_manager.GoModPackageManagerImpl{Paths: configuration.GoBinaryPaths},
+		package_manager.MavenPackageManagerImpl{Paths: configuration.JavaPaths},
+		package_manager.GemPackageManagerImpl{GemPaths: configuration.GemPaths},
+		package_manager.CargoPackageManagerImpl{Paths: configuration.RustBinaryPaths},
+		package_manager.OpkgPackageManagerImpl{},
+		package_manager.PortagePackageManagerImpl{},
+		package_manager.SnapPackageManagerImpl{},
+		package_manager.FlatpakPackageManagerImpl{},
+		package_manager.NixPackageManagerImpl{},
+	}
+
+	packageManagers, err := selectPackageManagers(allPackageManagers, configuration.PackageManagers)
+	if err != nil {
+		fmt.Printf("configuration error: %s", err)
+		os.Exit(-1)
+	}
+
+	// Scan an image file instead of the system, without docker and without
+	// sending a report
+	if configuration.Image != "" {
+		image, err := ScanImageFile(packageManagers, configuration.Image)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error scanning image: %s\n", err)
+			os.Exit(-2)
+		}
+
+		imageAsJson, err := json.Marshal(image)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error marshalling image: %s\n", err)
+			os.Exit(-3)
+		}
+
+		fmt.Println(string(imageAsJson))
+		return
+	}
+
+	dockerOptions := DockerOptions{
+		All:    configuration.DockerAll,
+		Images: configuration.DockerImages,
 	}
 
-	report, err := report(packageManagers)
+	report, err := report(packageManagers, configuration.Verify, dockerOptions)
 	if err != nil {
 		fmt.Printf("error generating report: %s", err)
 		os.Exit(-2)
@@ -94,6 +167,19 @@ func getConfiguration() (Configuration, error) {
 	apiEndpointPtr := flag.String("api-endpoint", "http://localhost:1080/api/v1", "The API endpoint URL")
 	apiKeyPtr := flag.String("api-key", "", "The API key")
 	logLevelAsStringPtr := flag.String("log-level", "info", "Log level")
+	packageManagersPtr := flag.String("package-managers", "", "Comma separated ids of the package managers to use, all when empty")
+	virtualenvRootsPtr := flag.String("virtualenv-roots", "/opt,/srv", "Comma separated directories searched for Python virtualenvs")
+	nodeRootsPtr := flag.String("node-roots", "/app,/usr/src/app,/opt,/srv,/usr/lib/node_modules,/usr/local/lib/node_modules", "Comma separated directories searched for node_modules")
+	nodeMaxDepthPtr := flag.Int("node-max-depth", package_manager.NpmDefaultMaxDepth, "Maximum directory depth searched below a node root")
+	nodeMaxFilesPtr := flag.Int("node-max-files", package_manager.NpmDefaultMaxFiles, "Maximum number of node package files read")
+	goBinaryPathsPtr := flag.String("go-binary-paths", "/usr/local/bin,/usr/local/sbin,/go/bin,/app,/opt,/srv", "Comma separated directories searched for Go binaries, directly and in bin and sbin directories in them or one level below")
+	javaPathsPtr := flag.String("java-paths", "/app,/opt,/srv,/usr/share/java,/usr/local/tomcat", "Comma separated directories searched for JAR, WAR and EAR files")
+	gemPathsPtr := flag.String("gem-paths", "/usr/lib/ruby/gems/*,/usr/local/lib/ruby/gems/*,/var/lib/gems/*,/usr/share/gems,/usr/local/bundle", "Comma separated Ruby gem paths, may contain glob patterns")
+	rustBinaryPathsPtr := flag.String("rust-binary-paths", "/usr/local/bin,/usr/local/sbin,/app,/opt,/srv", "Comma separated directories searched for Rust binaries, directly and in bin and sbin directories in them or one level below")
+	verifyPtr := flag.Bool("verify", false, "Verify the files of the host packages against their checksums")
+	dockerAllPtr := flag.Bool("docker-all", false, "Also scan stopped and created docker containers")
+	dockerImagesPtr := flag.Bool("docker-images", false, "Also scan all docker images, also those without containers")
+	imagePtr := flag.String("image", "", "Scan the OCI image layout directory or docker save archive and print its packages instead of reporting")
 
 	flag.Parse()
 
@@ -128,29 +214,91 @@ func getConfiguration() (Configuration, error) {
 	}
 
 	// Validation
-	if *apiKeyPtr == "" {
+	if *apiKeyPtr == "" && *imagePtr == "" {
 		return Configuration{}, fmt.Errorf("api-key not specified")
 	}
 
 	configuration := Configuration{
-		APIEndpoint: *apiEndpointPtr,
-		APIKey:      *apiKeyPtr,
-		LogLevel:    logLevel,
+		APIEndpoint:     *apiEndpointPtr,
+		APIKey:          *apiKeyPtr,
+		LogLevel:        logLevel,
+		VirtualenvRoots: splitList(*virtualenvRootsPtr),
+		NodeRoots:       splitList(*nodeRootsPtr),
+		NodeMaxDepth:    *nodeMaxDepthPtr,
+		NodeMaxFiles:    *nodeMaxFilesPtr,
+		GoBinaryPaths:   splitList(*goBinaryPathsPtr),
+		JavaPaths:       splitList(*javaPathsPtr),
+		GemPaths:        splitList(*gemPathsPtr),
+		RustBinaryPaths: splitList(*rustBinaryPathsPtr),
+		PackageManagers: splitList(*packageManagersPtr),
+		Verify:          *verifyPtr,
+		DockerAll:       *dockerAllPtr,
+		DockerImages:    *dockerImagesPtr,
+		Image:           *imagePtr,
 	}
 
 	return configuration, nil
 }
 
-func report(packageManagers []package_manager.PackageManager) (*Report, error) {
+// Split a comma separated command line value, ignoring empty elements
+func splitList(value string) []string {
+	var list []string
+
+	for _, element := range strings.Split(value, ",") {
+		element = strings.TrimSpace(element)
+		if element != "" {
+			list = append(list, element)
+		}
+	}
+
+	return list
+}
+
+// Select the package managers with the given ids, or all of them if no ids
+// are given
+func selectPackageManagers(packageManagers []package_manager.PackageManager, ids []string) ([]package_manager.PackageManager, error) {
+	if len(ids) == 0 {
+		return packageManagers, nil
+	}
+
+	var selected []package_manager.PackageManager
+
+	for _, id := range ids {
+		found := false
+
+		for _, packageManager := range packageManagers {
+			if packageManager.Id() == id {
+				selected = append(selected, packageManager)
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("unknown package manager \"%s\"", id)
+		}
+	}
+
+	return selected, nil
+}
+
+func report(packageManagers []package_manager.PackageManager, verify bool, dockerOptions DockerOptions) (*Report, error) {
 	// Figure out system wide packages
-	reportPackages, err := getPackages(packageManagers)
+	allPackages, err := getPackages(packageManagers)
 	if err != nil {
 		return nil, err
 	}
+	reportPackages, reportPartialPackages := package_manager.SplitInstalled(allPackages)
 
-	// Figure out the packages in the docker containers
+	// Verify the files of the system wide packages, which takes a while
+	var reportVerification *package_manager.Verification
+	if verify {
+		reportVerification = getVerification(packageManagers)
+	}
+
+	// Figure out the packages in the docker containers and their images, every
+	// image is scanned once
 	var reportDockerContainers []DockerContainer
-	dockerPackages, err := GetDockerPackages(packageManagers)
+	dockerPackages, reportDockerImages, err := GetDockerPackages(packageManagers, dockerOptions)
 	if err != nil {
 		Log.Debugf("getting docker container packages failed, likely simply no docker: %v", err)
 	} else {
@@ -166,6 +314,12 @@ func report(packageManagers []package_manager.PackageManager) (*Report, error) {
 		return nil, err
 	}
 
+	// Figure out the distribution, unknown on systems without release files
+	osRelease, err := package_manager.ReadOsRelease(package_manager.DirFS("/"))
+	if err != nil {
+		Log.Debugf("getting os release failed: %v", err)
+	}
+
 	// Generate a UUID for the report
 	rand.Seed(time.Now().UnixNano())
 	uuidAsBytes := make([]byte, 32)
@@ -177,11 +331,16 @@ func report(packageManagers []package_manager.PackageManager) (*Report, error) {
 
 	// The final report to send
 	report := Report{
-		UUID:     uuid,
-		Hostname: hostname,
-		Time:     time.Now().Unix(),
-		Packages: reportPackages,
-		Docker:   reportDockerContainers,
+		UUID:         uuid,
+		Hostname:     hostname,
+		OsRelease:    osRelease,
+		Time:         time.Now().Unix(),
+		Packages:     reportPackages,
+		Dependencies: package_manager.NewDependencyGraph(reportPackages).Edges(),
+		Docker:       reportDockerContainers,
+		Images:       reportDockerImages,
+		Verification: reportVerification,
+		Partial:      reportPartialPackages,
 	}
 
 	return &report, nil
@@ -190,27 +349,18 @@ func report(packageManagers []package_manager.PackageManager) (*Report, error) {
 // Get system package for provided package managers
 func getPackages(packageManagers []package_manager.PackageManager) ([]package_manager.Package, error) {
 	var allPackages []package_manager.Package
+	fsys := package_manager.DirFS("/")
 
 	for _, packageManager := range packageManagers {
-		var files = packageManager.FilesNeeded()
-
-		// Figure out if all files required by the package manager exist
-		var allFilesPresent = true
-		for _, file := range files {
-			info, err := os.Stat(file)
-			if os.IsNotExist(err) {
-				allFilesPresent = false
-			} else {
-				if info.IsDir() {
-					allFilesPresent = false
-				}
-			}
-		}
-
-		if allFilesPresent {
+		// Figure out if what is required by the package manager exists
+		if package_manager.Available(fsys, packageManager.FilesNeeded()) {
 			Log.Debugf("found package manager: %s\n", packageManager.Id())
 
-			packages := packageManager.Get(files)
+			// Problems with some files don't affect the other packages
+			packages, err := packageManager.Get(fsys)
+			if err != nil {
+				Log.Warnf("error getting packages of %s: %v", packageManager.Id(), err)
+			}
 
 			allPackages = append(allPackages, packages...)
 
@@ -225,6 +375,36 @@ func getPackages(packageManagers []package_manager.PackageManager) ([]package_ma
 	return allPackages, nil
 }
 
+// Verify the system files for the provided package managers which support it
+func getVerification(packageManagers []package_manager.PackageManager) *package_manager.Verification {
+	var verification package_manager.Verification
+	unowned := make(map[string]bool)
+
+	for _, packageManager := range packageManagers {
+		verifier, ok := packageManager.(package_manager.Verifier)
+		if !ok || !package_manager.Available(package_manager.DirFS("/"), packageManager.FilesNeeded()) {
+			continue
+		}
+
+		Log.Debugf("verifying files of package manager: %s\n", packageManager.Id())
+
+		result, err := verifier.Verify(package_manager.DirFS("/"))
+		if err != nil {
+			Log.Warnf("error verifying files of %s: %v", packageManager.Id(), err)
+		}
+		verification.Packages = append(verification.Packages, result.Packages...)
+
+		for _, file := range result.Unowned {
+			if !unowned[file] {
+				unowned[file] = true
+				verification.Unowned = append(verification.Unowned, file)
+			}
+		}
+	}
+
+	return &verification
+}
+
 // Generate a random temporary filename
 func TempFileName(prefix string) string {
 	randBytes := make([]byte, 16)
@@ -6,11 +6,24 @@ Copyright 2020 Zededa Inc.
 package package_manager
 
 import (
+	"archive/tar"
 	"bufio"
+	"compress/gzip"
+	"fmt"
 	"io"
-	"log"
-	"os"
+	"io/fs"
+	"strconv"
 	"strings"
+
+	"q-jam.nl/c/c-client/package_manager/versions"
+)
+
+// The world file holds the dependencies asked for by the user, the cache holds
+// the indexes of the repositories of the last update
+const (
+	apkInstalledFile = "/lib/apk/db/installed"
+	apkWorldFile     = "/etc/apk/world"
+	apkIndexPattern  = "/var/cache/apk/APKINDEX.*.tar.gz"
 )
 
 type ApkPackageManagerImpl struct{}
@@ -20,54 +33,166 @@ func (ApkPackageManagerImpl) Id() string {
 	return "apk"
 }
 
-func (ApkPackageManagerImpl) FilesNeeded() []string {
-	return []string{"/lib/apk/db/installed"}
+// Files needed by the manager
+func (ApkPackageManagerImpl) FilesNeeded() []FileSpec {
+	return []FileSpec{
+		{Path: apkInstalledFile},
+		{Path: apkWorldFile, Optional: true},
+		{Path: apkIndexPattern, Kind: GlobPattern, Optional: true},
+	}
 }
 
-func (ApkPackageManagerImpl) Get(files []string) []Package {
-	file, err := os.Open(files[0])
+// Get the packages installed in the file system
+func (ApkPackageManagerImpl) Get(fsys fs.FS) ([]Package, error) {
+	file, err := fsys.Open(fsName(apkInstalledFile))
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer file.Close()
 
-	reader := bufio.NewReader(file)
-	var line string
+	var errs ErrorList
 
-	var packages []Package
+	packages, err := parseApkInstalled(file, apkInstalledFile)
+	errs.add(err)
+
+	if Present(fsys, FileSpec{Path: apkWorldFile}) {
+		world, err := readApkWorld(fsys, apkWorldFile)
+		errs.add(err)
+
+		for i := range packages {
+			if world[packages[i].Name] {
+				packages[i].InstallReason = InstallReasonExplicit
+			} else {
+				packages[i].InstallReason = InstallReasonAutomatic
+			}
+		}
+	}
+
+	// Newer versions in the cached indexes
+	indexes := Glob(fsys, apkIndexPattern)
+	if len(indexes) > 0 {
+		available := newCandidates(versions.CompareApk, packages)
+		for _, file := range indexes {
+			errs.add(readApkIndex(fsys, file, available))
+		}
 
-	var name string
-	var version string
+		for i := range packages {
+			available.apply(&packages[i])
+		}
+	}
+
+	return packages, errs.err()
+}
 
+// Add the packages of a cached repository index to the candidates. The index
+// is a gzipped tar with the signature, a description and the APKINDEX file,
+// which has the format of the installed database.
+func readApkIndex(fsys fs.FS, file string, available *candidates) error {
+	f, err := fsys.Open(fsName(file))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gzipReader, err := gzip.NewReader(f)
+	if err != nil {
+		return &ParseError{File: file, Err: err}
+	}
+
+	tarReader := tar.NewReader(gzipReader)
 	for {
-		line, err = reader.ReadString('\n')
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return &ParseError{File: file, Err: fmt.Errorf("no APKINDEX")}
+		}
+		if err != nil {
+			return &ParseError{File: file, Err: err}
+		}
+
+		if header.Name != "APKINDEX" {
+			continue
+		}
+
+		packages, err := parseApkInstalled(tarReader, file)
+		for _, pkg := range packages {
+			available.add(pkg.Name, pkg.Architecture, pkg.Version, false)
+		}
+
+		return err
+	}
+}
+
+// Parse the installed database, which has a stanza of single letter fields
+// per package. Stray empty lines are skipped, the name of the file is used in
+// the returned ErrorList.
+func parseApkInstalled(reader io.Reader, name string) ([]Package, error) {
+	bufferedReader := bufio.NewReader(reader)
+
+	var errs ErrorList
+	var packages []Package
+
+	pkg := Package{Manager: "apk"}
+	// Line of the first field of the package, 0 before the first field
+	var packageLine int
+
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := bufferedReader.ReadString('\n')
 		if err != nil && err != io.EOF {
+			errs.addf(name, lineNumber, "%v", err)
 			break
 		}
 
 		line = strings.TrimSuffix(line, "\n")
 
-		if line != "" {
-			if strings.HasPrefix(line, "P:") {
-				var split []string = strings.SplitN(line, ":", 2)
-
-				name = split[1]
-			} else if strings.HasPrefix(line, "V:") {
-				var split []string = strings.SplitN(line, ":", 2)
+		if len(line) >= 2 && line[1] == ':' {
+			if packageLine == 0 {
+				packageLine = lineNumber
+			}
 
-				version = split[1]
+			value := line[2:]
+
+			switch line[0] {
+			case 'P':
+				pkg.Name = value
+			case 'V':
+				pkg.Version = value
+			case 'A':
+				pkg.Architecture = value
+			case 'o':
+				pkg.Source = value
+			case 'L':
+				pkg.License = value
+			case 'm':
+				pkg.Maintainer = value
+			case 'I':
+				pkg.Size, _ = strconv.ParseInt(value, 10, 64)
+			case 't':
+				pkg.BuildTime, _ = strconv.ParseInt(value, 10, 64)
+			case 'c':
+				pkg.Commit = value
+			case 'D':
+				for _, dependency := range parseApkDependencies(value) {
+					pkg.Depends = append(pkg.Depends, []Dependency{dependency})
+				}
+			case 'p':
+				pkg.Provides = parseApkDependencies(value)
 			}
+		} else if line != "" {
+			errs.addf(name, lineNumber, "malformed line %q", line)
 		}
 
 		// End of package definition?
 		if line == "" || err != nil {
-			packages = append(packages,
-				Package{
-					Name:    name,
-					Version: version,
-					Manager: "apk",
-				},
-			)
+			if packageLine != 0 {
+				if pkg.Name != "" {
+					packages = append(packages, pkg)
+				} else {
+					errs.addf(name, packageLine, "package without name")
+				}
+			}
+
+			pkg = Package{Manager: "apk"}
+			packageLine = 0
 		}
 
 		if err != nil {
@@ -75,5 +200,53 @@ func (ApkPackageManagerImpl) Get(files []string) []Package {
 		}
 	}
 
-	return packages
+	return packages, errs.err()
+}
+
+// Get the names of the packages in the world file. Dependencies may have a
+// version constraint or repository tag, like busybox>=1.32 or foo@testing, and
+// conflicts start with an exclamation mark.
+func readApkWorld(fsys fs.FS, file string) (map[string]bool, error) {
+	data, err := fs.ReadFile(fsys, fsName(file))
+	if err != nil {
+		return nil, err
+	}
+
+	world := make(map[string]bool)
+
+	for _, dependency := range strings.Fields(string(data)) {
+		if strings.HasPrefix(dependency, "!") {
+			continue
+		}
+
+		if i := strings.IndexAny(dependency, "=<>~@"); i >= 0 {
+			dependency = dependency[:i]
+		}
+
+		world[dependency] = true
+	}
+
+	return world, nil
+}
+
+// Parse a space separated list of dependencies or provides, like
+// "so:libc.musl-x86_64.so.1 busybox>=1.32". Conflicts are skipped.
+func parseApkDependencies(value string) []Dependency {
+	var dependencies []Dependency
+
+	for _, field := range strings.Fields(value) {
+		if strings.HasPrefix(field, "!") {
+			continue
+		}
+
+		dependency := Dependency{Name: field}
+		if i := strings.IndexAny(field, "=<>~"); i >= 0 {
+			dependency.Name = field[:i]
+			dependency.Constraint = field[i:]
+		}
+
+		dependencies = append(dependencies, dependency)
+	}
+
+	return dependencies
 }
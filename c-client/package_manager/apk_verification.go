@@ -0,0 +1,114 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Compare the files of the packages with their checksums in the installed
+// database, like apk audit --system
+func (ApkPackageManagerImpl) Verify(fsys fs.FS) (Verification, error) {
+	file, err := fsys.Open(fsName(apkInstalledFile))
+	if err != nil {
+		return Verification{}, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+
+	var verification Verification
+	var owned []string
+
+	pkg := PackageVerification{Manager: "apk"}
+	var directory string
+	var current string
+
+	// The checksum follows the file it belongs to, after the a: line with
+	// its owner and mode if those differ from the defaults. A file without
+	// checksum is checked when the next file, directory or package starts.
+	checkCurrent := func(checksum string) {
+		if current == "" {
+			return
+		}
+
+		newHash, sum := parseApkChecksum(checksum)
+		pkg.add(current, verifyFile(fsys, current, newHash, sum))
+		current = ""
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return verification, &ParseError{File: apkInstalledFile, Err: err}
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+
+		if len(line) > 2 && line[1] == ':' {
+			value := line[2:]
+
+			switch line[0] {
+			case 'P':
+				pkg.Name = value
+			case 'F':
+				checkCurrent("")
+				directory = path.Join("/", value)
+				owned = append(owned, directory)
+			case 'R':
+				checkCurrent("")
+				current = path.Join(directory, value)
+				owned = append(owned, current)
+			case 'Z':
+				checkCurrent(value)
+			}
+		}
+
+		// End of package definition?
+		if line == "" || err != nil {
+			checkCurrent("")
+
+			if len(pkg.Modified) > 0 || len(pkg.Missing) > 0 {
+				verification.Packages = append(verification.Packages, pkg)
+			}
+
+			pkg = PackageVerification{Manager: "apk"}
+			directory = ""
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	verification.Unowned = findUnowned(fsys, owned)
+
+	return verification, nil
+}
+
+// Decode a checksum, Q1 followed by a base64 encoded SHA-1 digest or a hex
+// encoded MD5 digest in older databases
+func parseApkChecksum(checksum string) (func() hash.Hash, []byte) {
+	if strings.HasPrefix(checksum, "Q1") {
+		sum, err := base64.StdEncoding.DecodeString(checksum[2:])
+		if err == nil && len(sum) == sha1.Size {
+			return sha1.New, sum
+		}
+	} else if sum, err := hex.DecodeString(checksum); err == nil && len(sum) == md5.Size {
+		return md5.New, sum
+	}
+
+	return nil, nil
+}
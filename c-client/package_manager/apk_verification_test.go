@@ -0,0 +1,75 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Files with an a: line between R: and Z:, like all executables, have their
+// checksum compared as well
+func TestApkVerify(t *testing.T) {
+	root := t.TempDir()
+
+	installed, err := os.ReadFile(filepath.Join("..", "testdata", "apk-installed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string][]byte{
+		apkInstalledFile: installed,
+		"/bin/busybox":   []byte("modified"),
+	} {
+		err = os.MkdirAll(filepath.Dir(Resolve(root, name)), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.WriteFile(Resolve(root, name), data, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The checksum of a symbolic link is the one of its target
+	err = os.Symlink("/bin/busybox", Resolve(root, "/bin/sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verification, err := ApkPackageManagerImpl{}.Verify(DirFS(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var busybox *PackageVerification
+	for i, pkg := range verification.Packages {
+		if pkg.Name == "busybox" {
+			busybox = &verification.Packages[i]
+		}
+	}
+
+	if busybox == nil {
+		t.Fatalf("no verification of busybox in %+v", verification.Packages)
+	}
+
+	if expected := []string{"/bin/busybox"}; !reflect.DeepEqual(busybox.Modified, expected) {
+		t.Errorf("modified %v, expected %v", busybox.Modified, expected)
+	}
+
+	for _, file := range busybox.Missing {
+		if file == "/bin/busybox" || file == "/bin/sh" {
+			t.Errorf("%s reported missing", file)
+		}
+	}
+
+	if len(busybox.Missing) == 0 {
+		t.Errorf("no missing files of busybox")
+	}
+}
@@ -0,0 +1,87 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"q-jam.nl/c/c-client/package_manager/versions"
+)
+
+// The newest versions of the installed packages in the local package indexes
+// of a package manager, like the apt lists. Versions are kept per name and
+// architecture, versions which can't be compared are ignored.
+type candidates struct {
+	compare   versions.CompareFunc
+	installed map[string]bool
+	newest    map[string]candidate
+}
+
+type candidate struct {
+	version  string
+	security bool
+}
+
+func newCandidates(compare versions.CompareFunc, packages []Package) *candidates {
+	c := &candidates{
+		compare:   compare,
+		installed: make(map[string]bool),
+		newest:    make(map[string]candidate),
+	}
+
+	for _, pkg := range packages {
+		c.installed[pkg.Name] = true
+	}
+
+	return c
+}
+
+// Add a version of a package found in an index, security is set for indexes of
+// a security pocket. The same version can be in several indexes, it is a
+// security update when any of them is a security pocket.
+func (c *candidates) add(name string, architecture string, version string, security bool) {
+	if !c.installed[name] {
+		return
+	}
+
+	key := name + "/" + architecture
+
+	current, ok := c.newest[key]
+	if !ok {
+		if _, err := c.compare(version, version); err == nil {
+			c.newest[key] = candidate{version: version, security: security}
+		}
+		return
+	}
+
+	result, err := c.compare(version, current.version)
+	if err != nil {
+		return
+	}
+
+	if result > 0 {
+		c.newest[key] = candidate{version: version, security: security}
+	} else if result == 0 && security {
+		current.security = true
+		c.newest[key] = current
+	}
+}
+
+// Set the candidate version of a package when a newer version is available
+func (c *candidates) apply(pkg *Package) {
+	newest, ok := c.newest[pkg.Name+"/"+pkg.Architecture]
+	if !ok {
+		return
+	}
+
+	result, err := c.compare(newest.version, pkg.Version)
+	if err != nil || result <= 0 {
+		return
+	}
+
+	pkg.Candidate = newest.version
+	if newest.security {
+		pkg.Flags = append(pkg.Flags, PackageFlagSecurityUpdate)
+	}
+}
@@ -0,0 +1,105 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"compress/zlib"
+	"debug/elf"
+	"encoding/json"
+	"io"
+	"io/fs"
+)
+
+// Maximum size of the decompressed dependency list
+const cargoAuditableMaxSize = 8 << 20
+
+// Finds Rust executables below Paths built with cargo auditable and reports
+// the crates recorded in their .dep-v0 section.
+type CargoPackageManagerImpl struct {
+	Paths []string
+}
+
+// Short name of the package manager
+func (CargoPackageManagerImpl) Id() string {
+	return "cargo"
+}
+
+// Files needed by the manager
+func (m CargoPackageManagerImpl) FilesNeeded() []FileSpec {
+	return elfFilesNeeded(m.Paths)
+}
+
+// Get the packages installed in the file system
+func (m CargoPackageManagerImpl) Get(fsys fs.FS) ([]Package, error) {
+	var packages []Package
+
+	err := walkElfFiles(fsys, elfFilesNeeded(m.Paths), func(name string, elfFile *elf.File) error {
+		crates, err := readCargoAuditable(elfFile)
+		if err != nil {
+			return err
+		}
+
+		for _, pkg := range crates {
+			pkg.Location = name
+			packages = append(packages, pkg)
+		}
+
+		return nil
+	})
+
+	return packages, err
+}
+
+type cargoAuditableInfo struct {
+	Packages []struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+		Kind    string `json:"kind"`
+	} `json:"packages"`
+}
+
+// Read the zlib compressed JSON dependency list, binaries built without
+// cargo auditable have none
+func readCargoAuditable(elfFile *elf.File) ([]Package, error) {
+	section := elfFile.Section(".dep-v0")
+	if section == nil {
+		return nil, nil
+	}
+
+	reader, err := zlib.NewReader(section.Open())
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, cargoAuditableMaxSize))
+	if err != nil {
+		return nil, err
+	}
+
+	var info cargoAuditableInfo
+	err = json.Unmarshal(data, &info)
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []Package
+
+	for _, crate := range info.Packages {
+		// Build dependencies are not part of the binary
+		if crate.Kind == "build" {
+			continue
+		}
+
+		packages = append(packages, Package{
+			Name:    crate.Name,
+			Version: crate.Version,
+			Manager: "cargo",
+		})
+	}
+
+	return packages, nil
+}
@@ -0,0 +1,77 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// A stanza of a Debian style control file (dpkg and opkg status files). Lines
+// of multi-line fields are joined with newlines, without the leading space.
+type controlStanza struct {
+	// Line number of the first field
+	Line   int
+	Fields map[string]string
+}
+
+// Read all stanzas from a control file, stanzas are separated by empty lines.
+// Malformed lines are skipped and reported in the returned ErrorList, with
+// the name of the file.
+func readControlStanzas(reader io.Reader, name string) ([]controlStanza, error) {
+	bufferedReader := bufio.NewReader(reader)
+
+	var errs ErrorList
+	var stanzas []controlStanza
+	stanza := controlStanza{Fields: make(map[string]string)}
+	var field string
+
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := bufferedReader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			errs.addf(name, lineNumber, "%v", err)
+			break
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+
+		if strings.TrimSpace(line) == "" {
+			if len(stanza.Fields) > 0 {
+				stanzas = append(stanzas, stanza)
+				stanza = controlStanza{Fields: make(map[string]string)}
+			}
+			field = ""
+		} else if line[0] == ' ' || line[0] == '\t' {
+			// Continuation of the previous field
+			if field != "" {
+				stanza.Fields[field] += "\n" + line[1:]
+			} else {
+				errs.addf(name, lineNumber, "continuation line without field")
+			}
+		} else if split := strings.SplitN(line, ":", 2); len(split) == 2 {
+			field = strings.TrimSpace(split[0])
+			stanza.Fields[field] = strings.TrimSpace(split[1])
+
+			if stanza.Line == 0 {
+				stanza.Line = lineNumber
+			}
+		} else {
+			errs.addf(name, lineNumber, "malformed line %q", line)
+			field = ""
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	if len(stanza.Fields) > 0 {
+		stanzas = append(stanzas, stanza)
+	}
+
+	return stanzas, errs.err()
+}
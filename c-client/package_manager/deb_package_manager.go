@@ -7,10 +7,14 @@ package package_manager
 
 import (
 	"bufio"
+	"compress/gzip"
 	"io"
-	"log"
-	"os"
+	"io/fs"
+	"path"
+	"strconv"
 	"strings"
+
+	"q-jam.nl/c/c-client/package_manager/versions"
 )
 
 type DebPackageManagerImpl struct{}
@@ -20,71 +24,358 @@ func (DebPackageManagerImpl) Id() string {
 	return "deb"
 }
 
-// Files needed by the manager
-func (DebPackageManagerImpl) FilesNeeded() []string {
-	return []string{"/var/lib/dpkg/status"}
+// The extended states of apt mark the packages installed as dependency, the
+// lists hold the packages available in the repositories at the last update
+const (
+	debStatusFile         = "/var/lib/dpkg/status"
+	debStatusDirectory    = "/var/lib/dpkg/status.d"
+	debExtendedStatesFile = "/var/lib/apt/extended_states"
+	debAptListsPattern    = "/var/lib/apt/lists/*_Packages"
+)
+
+// The apt lists kept compressed with Acquire::GzipIndexes, like in the docker
+// images of Debian and Ubuntu. Lists compressed with xz are not read.
+var debAptListsPatterns = []string{debAptListsPattern, debAptListsPattern + ".gz", debAptListsPattern + ".lz4"}
+
+// Words of the Status field with a special meaning, see dpkg-query(1)
+const (
+	debWantHold          = "hold"
+	debFlagReinstReq     = "reinstreq"
+	debFlagHold          = "hold"
+	debFlagHoldReinstReq = "hold-reinstreq"
+	debStateNotInstalled = "not-installed"
+	debStateInstalled    = "installed"
+	// Installed, only the triggers of the package have yet to run
+	debStateTriggersAwaited = "triggers-awaited"
+	debStateTriggersPending = "triggers-pending"
+)
+
+// Files needed by the manager. Distroless images have no status file, but a
+// status file per package in status.d instead.
+func (DebPackageManagerImpl) FilesNeeded() []FileSpec {
+	files := []FileSpec{
+		{Path: debStatusFile, Optional: true},
+		{Path: debStatusDirectory + "/*", Kind: GlobPattern, Optional: true},
+		{Path: debExtendedStatesFile, Optional: true},
+	}
+
+	for _, pattern := range debAptListsPatterns {
+		files = append(files, FileSpec{Path: pattern, Kind: GlobPattern, Optional: true})
+	}
+
+	return files
 }
 
-// Get the packages installed according to the supplied files
-func (DebPackageManagerImpl) Get(files []string) []Package {
-	file, err := os.Open(files[0])
+// Get the packages installed in the file system
+func (DebPackageManagerImpl) Get(fsys fs.FS) ([]Package, error) {
+	var packages []Package
+	var errs ErrorList
+
+	if Present(fsys, FileSpec{Path: debStatusFile}) {
+		statusPackages, err := readDebStatus(fsys, debStatusFile, false)
+		packages = append(packages, statusPackages...)
+		errs.add(err)
+	}
+
+	for _, file := range Glob(fsys, debStatusDirectory+"/*") {
+		// Newer distroless images also keep the md5sums of each package here
+		if strings.HasSuffix(file, ".md5sums") {
+			continue
+		}
+
+		statusPackages, err := readDebStatus(fsys, file, true)
+		packages = append(packages, statusPackages...)
+		errs.add(err)
+	}
+
+	if Present(fsys, FileSpec{Path: debExtendedStatesFile}) {
+		automatic, err := readDebExtendedStates(fsys, debExtendedStatesFile)
+		errs.add(err)
+
+		for i := range packages {
+			architectures := automatic[packages[i].Name]
+
+			// Packages for all architectures are recorded with the native
+			// architecture, older versions of apt don't record any
+			if architectures[packages[i].Architecture] || architectures[""] ||
+				(packages[i].Architecture == "all" && len(architectures) > 0) {
+				packages[i].InstallReason = InstallReasonAutomatic
+			} else {
+				packages[i].InstallReason = InstallReasonExplicit
+			}
+		}
+	}
+
+	// Newer versions in the apt lists, only installed packages can be upgraded
+	var lists []string
+	for _, pattern := range debAptListsPatterns {
+		lists = append(lists, Glob(fsys, pattern)...)
+	}
+
+	if len(lists) > 0 {
+		available := newCandidates(versions.CompareDpkg, packages)
+		for _, file := range lists {
+			errs.add(readAptPackages(fsys, file, available))
+		}
+
+		for i := range packages {
+			if packages[i].Installed() {
+				available.apply(&packages[i])
+			}
+		}
+	}
+
+	return packages, errs.err()
+}
+
+// Add the packages of a Packages file of the apt lists to the candidates. Only
+// the fields needed are read, the lists of large repositories have tens of
+// thousands of stanzas.
+func readAptPackages(fsys fs.FS, file string, available *candidates) error {
+	f, err := fsys.Open(fsName(file))
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer file.Close()
+	defer f.Close()
 
-	reader := bufio.NewReader(file)
+	var listReader io.Reader = f
+	switch path.Ext(file) {
+	case ".gz":
+		listReader, err = gzip.NewReader(f)
+	case ".lz4":
+		listReader, err = newLz4Reader(f)
+	}
+	if err != nil {
+		return &ParseError{File: file, Err: err}
+	}
 
-	var packages []Package
+	security := isAptSecurityList(file)
+	reader := bufio.NewReader(listReader)
 
-	var name string
-	var version string
-	var installed bool
+	var name, version, architecture string
 
-	for {
+	for lineNumber := 1; ; lineNumber++ {
 		line, err := reader.ReadString('\n')
 		if err != nil && err != io.EOF {
-			break
+			return &ParseError{File: file, Line: lineNumber, Err: err}
 		}
 
-		line = strings.TrimSuffix(line, "\n")
+		line = strings.TrimRight(line, "\r\n")
 
-		if strings.HasPrefix(line, "Package: ") {
-			var split []string = strings.SplitN(line, " ", 2)
+		if strings.HasPrefix(line, "Package:") {
+			name = strings.TrimSpace(line[len("Package:"):])
+		} else if strings.HasPrefix(line, "Version:") {
+			version = strings.TrimSpace(line[len("Version:"):])
+		} else if strings.HasPrefix(line, "Architecture:") {
+			architecture = strings.TrimSpace(line[len("Architecture:"):])
+		}
 
-			name = split[1]
-		} else if strings.HasPrefix(line, "Version: ") {
-			var split []string = strings.SplitN(line, " ", 2)
+		// End of the stanza
+		if strings.TrimSpace(line) == "" || err != nil {
+			if name != "" && version != "" {
+				available.add(name, architecture, version, security)
+			}
+			name, version, architecture = "", "", ""
+		}
 
-			version = split[1]
-		} else if strings.HasPrefix(line, "Status: ") {
-			var split []string = strings.SplitN(line, " ", 2)
+		if err != nil {
+			return nil
+		}
+	}
+}
 
-			status := split[1]
+// Check if a list is of a security pocket. The lists are named after the
+// repository URI and distribution, like
+// security.ubuntu.com_ubuntu_dists_bionic-security_main_binary-amd64_Packages
+// or security.debian.org_debian-security_dists_buster_updates_main_binary-amd64_Packages.
+func isAptSecurityList(file string) bool {
+	for i, element := range strings.Split(path.Base(file), "_") {
+		if strings.HasSuffix(element, "-security") || (i == 0 && strings.HasPrefix(element, "security.")) {
+			return true
+		}
+	}
+
+	return false
+}
 
-			// TODO Check if this is enough
-			if status == "install ok installed" {
-				installed = true
+// Get the packages marked as automatically installed in the extended states,
+// with the architectures per package name
+func readDebExtendedStates(fsys fs.FS, file string) (map[string]map[string]bool, error) {
+	f, err := fsys.Open(fsName(file))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stanzas, err := readControlStanzas(f, file)
+
+	automatic := make(map[string]map[string]bool)
+
+	for _, stanza := range stanzas {
+		if stanza.Fields["Auto-Installed"] != "1" {
+			continue
+		}
+
+		name := stanza.Fields["Package"]
+		if automatic[name] == nil {
+			automatic[name] = make(map[string]bool)
+		}
+		automatic[name][stanza.Fields["Architecture"]] = true
+	}
+
+	return automatic, err
+}
+
+// Open and parse a status file
+func readDebStatus(fsys fs.FS, file string, assumeInstalled bool) ([]Package, error) {
+	f, err := fsys.Open(fsName(file))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseDebStatus(f, file, assumeInstalled)
+}
+
+// Parse a status file. Packages are reported unless they are not installed at
+// all, with their state. The control files in status.d may lack a Status
+// field, in which case assumeInstalled decides. The name of the file is used
+// in the returned ErrorList.
+func parseDebStatus(reader io.Reader, name string, assumeInstalled bool) ([]Package, error) {
+	stanzas, err := readControlStanzas(reader, name)
+
+	var errs ErrorList
+	errs.add(err)
+
+	var packages []Package
+
+	for _, stanza := range stanzas {
+		fields := stanza.Fields
+
+		if fields["Package"] == "" {
+			errs.addf(name, stanza.Line, "stanza without Package field")
+			continue
+		}
+
+		pkg := Package{
+			Name:         fields["Package"],
+			Version:      fields["Version"],
+			Manager:      "deb",
+			Architecture: fields["Architecture"],
+			Maintainer:   fields["Maintainer"],
+		}
+
+		// The source version is only given when it differs
+		if source := strings.Fields(fields["Source"]); len(source) > 0 {
+			pkg.Source = source[0]
+		}
+
+		// In KiB
+		size, _ := strconv.ParseInt(fields["Installed-Size"], 10, 64)
+		pkg.Size = size * 1024
+
+		// Pre-dependencies only differ in when they have to be satisfied
+		pkg.Depends = append(parseDebRelations(fields["Pre-Depends"]), parseDebRelations(fields["Depends"])...)
+		for _, provides := range parseDebRelations(fields["Provides"]) {
+			pkg.Provides = append(pkg.Provides, provides...)
+		}
+
+		if status, ok := fields["Status"]; ok {
+			want, flag, state, valid := parseDebStatusField(status)
+			if !valid {
+				errs.addf(name, stanza.Line, "invalid status %q of %s", status, pkg.Name)
+				continue
+			}
+
+			if state == debStateNotInstalled {
+				continue
 			}
+
+			pkg.State = state
+
+			if want == debWantHold || flag == debFlagHold || flag == debFlagHoldReinstReq {
+				pkg.Flags = append(pkg.Flags, PackageFlagHeld)
+			}
+			if flag == debFlagReinstReq || flag == debFlagHoldReinstReq {
+				pkg.Flags = append(pkg.Flags, PackageFlagReinstRequired)
+			}
+		} else if assumeInstalled {
+			pkg.State = debStateInstalled
+		} else {
+			continue
 		}
 
-		// End of package definition?
-		if (line == "" || err != nil) && installed {
-			packages = append(packages,
-				Package{
-					Name:    name,
-					Version: version,
-					Manager: "deb",
-				},
-			)
+		packages = append(packages, pkg)
+	}
+
+	return packages, errs.err()
+}
+
+// Split the Status field into the selection state (want), the error flag and
+// the package state. Older versions of dpkg used hold and hold-reinstreq as
+// error flags.
+func parseDebStatusField(status string) (string, string, string, bool) {
+	fields := strings.Fields(status)
+	if len(fields) != 3 {
+		return "", "", "", false
+	}
+
+	want, flag, state := fields[0], fields[1], fields[2]
+
+	switch want {
+	case "unknown", "install", debWantHold, "deinstall", "purge":
+	default:
+		return "", "", "", false
+	}
+
+	switch flag {
+	case "ok", debFlagReinstReq, debFlagHold, debFlagHoldReinstReq:
+	default:
+		return "", "", "", false
+	}
 
-			installed = false
+	switch state {
+	case debStateNotInstalled, "config-files", "half-installed", "unpacked", "half-configured",
+		debStateTriggersAwaited, debStateTriggersPending, debStateInstalled:
+	default:
+		return "", "", "", false
+	}
+
+	return want, flag, state, true
+}
+
+// Parse a relationship field like "libc6 (>= 2.14), debconf | debconf-2.0"
+// into groups of alternatives. Architecture qualifiers like :any are dropped.
+func parseDebRelations(value string) [][]Dependency {
+	var relations [][]Dependency
+
+	for _, group := range strings.Split(value, ",") {
+		var alternatives []Dependency
+
+		for _, alternative := range strings.Split(group, "|") {
+			alternative = strings.TrimSpace(alternative)
+			if alternative == "" {
+				continue
+			}
+
+			var dependency Dependency
+			if i := strings.Index(alternative, "("); i >= 0 {
+				dependency.Constraint = strings.Join(strings.Fields(strings.Trim(alternative[i:], "()")), " ")
+				alternative = strings.TrimSpace(alternative[:i])
+			}
+
+			if i := strings.Index(alternative, ":"); i >= 0 {
+				alternative = alternative[:i]
+			}
+			dependency.Name = alternative
+
+			alternatives = append(alternatives, dependency)
 		}
 
-		if err != nil {
-			break
+		if len(alternatives) > 0 {
+			relations = append(relations, alternatives)
 		}
 	}
 
-	return packages
+	return relations
 }
@@ -0,0 +1,69 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// Candidates from apt lists kept compressed, like in docker images. Packages
+// waiting for triggers are installed as well.
+func TestDebCandidates(t *testing.T) {
+	fsys := fstest.MapFS{}
+
+	files := map[string]string{
+		"debian-status": "var/lib/dpkg/status",
+		"apt-lists/archive.ubuntu.com_ubuntu_dists_bionic-updates_main_binary-amd64_Packages.lz4":  "var/lib/apt/lists/archive.ubuntu.com_ubuntu_dists_bionic-updates_main_binary-amd64_Packages.lz4",
+		"apt-lists/security.ubuntu.com_ubuntu_dists_bionic-security_main_binary-amd64_Packages.gz": "var/lib/apt/lists/security.ubuntu.com_ubuntu_dists_bionic-security_main_binary-amd64_Packages.gz",
+	}
+	for file, name := range files {
+		data, err := os.ReadFile(filepath.Join("..", "testdata", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fsys[name] = &fstest.MapFile{Data: data}
+	}
+
+	status := fsys["var/lib/dpkg/status"]
+	data := strings.Replace(string(status.Data), "Package: bash\nEssential: yes\nStatus: install ok installed\n", "Package: bash\nEssential: yes\nStatus: install ok triggers-pending\n", 1)
+	if data == string(status.Data) {
+		t.Fatal("bash not in status")
+	}
+	status.Data = []byte(data)
+
+	packages, err := DebPackageManagerImpl{}.Get(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type candidate struct {
+		version string
+		flags   []string
+	}
+
+	expected := map[string]candidate{
+		"bash":    {"4.4.18-2ubuntu1.3", nil},
+		"libc6":   {"2.27-3ubuntu1.4", []string{PackageFlagSecurityUpdate}},
+		"openssl": {"1.1.1-1ubuntu2.1~18.04.7", []string{PackageFlagSecurityUpdate}},
+	}
+
+	found := make(map[string]candidate)
+	for _, pkg := range packages {
+		if pkg.Candidate != "" {
+			found[pkg.Name] = candidate{pkg.Candidate, pkg.Flags}
+		}
+	}
+
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("candidates %v, expected %v", found, expected)
+	}
+}
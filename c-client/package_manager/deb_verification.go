@@ -0,0 +1,140 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Every package has a list of its files and the MD5 digests of the files which
+// are not configuration files. The files of multi-arch packages are named
+// <name>:<arch>.list.
+const (
+	debInfoDirectory  = "/var/lib/dpkg/info"
+	debDiversionsFile = "/var/lib/dpkg/diversions"
+)
+
+// Compare the files of the packages with the lists and digests in the info
+// directory, like debsums. Configuration files are only checked for presence
+// and diverted files are skipped.
+func (DebPackageManagerImpl) Verify(fsys fs.FS) (Verification, error) {
+	diverted := readDebDiversions(fsys, debDiversionsFile)
+
+	var verification Verification
+	var errs ErrorList
+	var owned []string
+
+	for _, list := range Glob(fsys, debInfoDirectory+"/*.list") {
+		base := strings.TrimSuffix(list, ".list")
+
+		pkg := PackageVerification{
+			Name:    strings.SplitN(path.Base(base), ":", 2)[0],
+			Manager: "deb",
+		}
+
+		checksums := readDebMd5sums(fsys, base+".md5sums")
+
+		files, err := readDebList(fsys, list)
+		if err != nil {
+			errs.add(err)
+			continue
+		}
+
+		for _, file := range files {
+			owned = append(owned, file)
+
+			if diverted[file] {
+				continue
+			}
+
+			var sum []byte
+			if checksum, ok := checksums[file]; ok {
+				sum, _ = hex.DecodeString(checksum)
+			}
+
+			pkg.add(file, verifyFile(fsys, file, md5.New, sum))
+		}
+
+		if len(pkg.Modified) > 0 || len(pkg.Missing) > 0 {
+			verification.Packages = append(verification.Packages, pkg)
+		}
+	}
+
+	for file := range diverted {
+		owned = append(owned, file)
+	}
+
+	verification.Unowned = findUnowned(fsys, owned)
+
+	return verification, errs.err()
+}
+
+// Read the paths in a file list, the root directory is listed as /.
+func readDebList(fsys fs.FS, file string) ([]string, error) {
+	data, err := fs.ReadFile(fsys, fsName(file))
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" || line == "/." {
+			continue
+		}
+
+		files = append(files, path.Clean(line))
+	}
+
+	return files, nil
+}
+
+// Read the digests per path, the paths are listed without leading slash
+func readDebMd5sums(fsys fs.FS, file string) map[string]string {
+	checksums := make(map[string]string)
+
+	f, err := fsys.Open(fsName(file))
+	if err != nil {
+		return checksums
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		split := strings.SplitN(scanner.Text(), "  ", 2)
+		if len(split) != 2 {
+			continue
+		}
+
+		checksums[path.Join("/", split[1])] = split[0]
+	}
+
+	return checksums
+}
+
+// Get the diverted paths, the diversions file has the original path, the
+// diverted path and the diverting package on three lines per diversion. Both
+// paths are considered diverted.
+func readDebDiversions(fsys fs.FS, file string) map[string]bool {
+	diverted := make(map[string]bool)
+
+	data, err := fs.ReadFile(fsys, fsName(file))
+	if err != nil {
+		return diverted
+	}
+
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i+1 < len(lines); i += 3 {
+		diverted[lines[i]] = true
+		diverted[lines[i+1]] = true
+	}
+
+	return diverted
+}
@@ -0,0 +1,152 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"sort"
+)
+
+// A dependency on, or a virtual package provided by, a package. The constraint
+// is the version relation as written by the package manager, like >= 2.14.
+type Dependency struct {
+	Name       string `json:"n"`
+	Constraint string `json:"c,omitempty"`
+}
+
+// The resolved dependencies between packages. Dependencies are only resolved
+// between packages of the same manager, a dependency on a virtual package
+// resolves to all installed packages providing it and of alternatives the
+// first installed one is used. Version constraints are not checked.
+type DependencyGraph struct {
+	packages     []Package
+	byName       map[string][]int
+	dependencies [][]int
+	reverse      [][]int
+}
+
+// Resolve the dependencies of packages
+func NewDependencyGraph(packages []Package) *DependencyGraph {
+	g := &DependencyGraph{
+		packages:     packages,
+		byName:       make(map[string][]int),
+		dependencies: make([][]int, len(packages)),
+		reverse:      make([][]int, len(packages)),
+	}
+
+	providers := make(map[string][]int)
+	for i, pkg := range packages {
+		g.byName[graphKey(pkg.Manager, pkg.Name)] = append(g.byName[graphKey(pkg.Manager, pkg.Name)], i)
+
+		for _, provide := range pkg.Provides {
+			providers[graphKey(pkg.Manager, provide.Name)] = append(providers[graphKey(pkg.Manager, provide.Name)], i)
+		}
+	}
+
+	for i, pkg := range packages {
+		seen := make(map[int]bool)
+
+		for _, alternatives := range pkg.Depends {
+			for _, alternative := range alternatives {
+				key := graphKey(pkg.Manager, alternative.Name)
+
+				resolved := append(append([]int(nil), g.byName[key]...), providers[key]...)
+				if len(resolved) == 0 {
+					continue
+				}
+
+				for _, j := range resolved {
+					if j == i || seen[j] {
+						continue
+					}
+					seen[j] = true
+
+					g.dependencies[i] = append(g.dependencies[i], j)
+					g.reverse[j] = append(g.reverse[j], i)
+				}
+				break
+			}
+		}
+
+		sort.Ints(g.dependencies[i])
+	}
+
+	return g
+}
+
+// The resolved dependencies as pairs of indexes of the package and the package
+// it depends on
+func (g *DependencyGraph) Edges() [][2]int {
+	var edges [][2]int
+	for i, dependencies := range g.dependencies {
+		for _, j := range dependencies {
+			edges = append(edges, [2]int{i, j})
+		}
+	}
+
+	return edges
+}
+
+// Get the packages the named package directly depends on
+func (g *DependencyGraph) Dependencies(manager string, name string) []Package {
+	return g.collect(g.dependencies, manager, name, false)
+}
+
+// Get the packages directly depending on the named package
+func (g *DependencyGraph) ReverseDependencies(manager string, name string) []Package {
+	return g.collect(g.reverse, manager, name, false)
+}
+
+// Get all packages which directly or indirectly depend on the named package,
+// which are the packages affected when it breaks
+func (g *DependencyGraph) AllReverseDependencies(manager string, name string) []Package {
+	return g.collect(g.reverse, manager, name, true)
+}
+
+// Collect the packages reachable from the named package, in the order of the
+// package list
+func (g *DependencyGraph) collect(edges [][]int, manager string, name string, transitive bool) []Package {
+	start := g.byName[graphKey(manager, name)]
+
+	reached := make(map[int]bool)
+	queue := append([]int(nil), start...)
+
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+
+		for _, j := range edges[i] {
+			if reached[j] {
+				continue
+			}
+			reached[j] = true
+
+			if transitive {
+				queue = append(queue, j)
+			}
+		}
+	}
+
+	for _, i := range start {
+		delete(reached, i)
+	}
+
+	var indexes []int
+	for i := range reached {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	var packages []Package
+	for _, i := range indexes {
+		packages = append(packages, g.packages[i])
+	}
+
+	return packages
+}
+
+func graphKey(manager string, name string) string {
+	return manager + "\x00" + name
+}
@@ -0,0 +1,68 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Of alternatives the first installed one is used, a virtual package resolves
+// to all packages providing it, packages of other managers are left out and
+// cycles end
+func TestDependencyGraph(t *testing.T) {
+	packages := []Package{
+		{Name: "app", Manager: "deb", Depends: [][]Dependency{
+			{{Name: "libssl3"}, {Name: "libssl1.1", Constraint: ">= 1.1.1"}},
+			{{Name: "mail-transport-agent"}},
+			{{Name: "app"}},
+		}},
+		{Name: "libssl1.1", Manager: "deb", Depends: [][]Dependency{{{Name: "libc6"}}}},
+		{Name: "postfix", Manager: "deb", Provides: []Dependency{{Name: "mail-transport-agent"}}, Depends: [][]Dependency{{{Name: "libc6"}}}},
+		{Name: "exim4", Manager: "deb", Provides: []Dependency{{Name: "mail-transport-agent"}}},
+		{Name: "libc6", Manager: "deb", Depends: [][]Dependency{{{Name: "libgcc-s1"}}}},
+		{Name: "libgcc-s1", Manager: "deb", Depends: [][]Dependency{{{Name: "libc6"}}}},
+		{Name: "libc6", Manager: "rpm"},
+		{Name: "tool", Manager: "rpm", Depends: [][]Dependency{{{Name: "libc6"}}, {{Name: "missing"}}}},
+	}
+
+	g := NewDependencyGraph(packages)
+
+	edges := [][2]int{{0, 1}, {0, 2}, {0, 3}, {1, 4}, {2, 4}, {4, 5}, {5, 4}, {7, 6}}
+	if !reflect.DeepEqual(g.Edges(), edges) {
+		t.Errorf("edges %v, expected %v", g.Edges(), edges)
+	}
+
+	for _, test := range []struct {
+		function string
+		get      func(manager string, name string) []Package
+		manager  string
+		name     string
+		expected []string
+	}{
+		{"Dependencies", g.Dependencies, "deb", "app", []string{"libssl1.1", "postfix", "exim4"}},
+		{"Dependencies", g.Dependencies, "deb", "libc6", []string{"libgcc-s1"}},
+		{"Dependencies", g.Dependencies, "rpm", "tool", []string{"libc6"}},
+		{"Dependencies", g.Dependencies, "deb", "mail-transport-agent", nil},
+		{"ReverseDependencies", g.ReverseDependencies, "deb", "libc6", []string{"libssl1.1", "postfix", "libgcc-s1"}},
+		{"ReverseDependencies", g.ReverseDependencies, "deb", "exim4", []string{"app"}},
+		{"ReverseDependencies", g.ReverseDependencies, "deb", "app", nil},
+		{"ReverseDependencies", g.ReverseDependencies, "rpm", "libc6", []string{"tool"}},
+		{"AllReverseDependencies", g.AllReverseDependencies, "deb", "libc6", []string{"app", "libssl1.1", "postfix", "libgcc-s1"}},
+		{"AllReverseDependencies", g.AllReverseDependencies, "deb", "libgcc-s1", []string{"app", "libssl1.1", "postfix", "libc6"}},
+		{"AllReverseDependencies", g.AllReverseDependencies, "deb", "postfix", []string{"app"}},
+		{"AllReverseDependencies", g.AllReverseDependencies, "deb", "unknown", nil},
+	} {
+		var names []string
+		for _, pkg := range test.get(test.manager, test.name) {
+			names = append(names, pkg.Name)
+		}
+
+		if !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("%s(%s, %s): %v, expected %v", test.function, test.manager, test.name, names, test.expected)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var errFileTooLarge = errors.New("file too large")
+
+// A problem with a file read by a package manager, like a malformed stanza or
+// a file which can't be read. Line is 0 when the problem is not tied to a line.
+type ParseError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+	}
+
+	return fmt.Sprintf("%s: %v", e.File, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// All problems found by a package manager. Get still returns the packages it
+// could read when it returns an ErrorList.
+type ErrorList []error
+
+func (l ErrorList) Error() string {
+	var messages []string
+	for _, err := range l {
+		messages = append(messages, err.Error())
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// Add an error, errors of nested lists are added one by one
+func (l *ErrorList) add(err error) {
+	if err == nil {
+		return
+	}
+
+	var list ErrorList
+	if errors.As(err, &list) {
+		*l = append(*l, list...)
+		return
+	}
+
+	*l = append(*l, err)
+}
+
+// Add a problem at a line of a file
+func (l *ErrorList) addf(file string, line int, format string, args ...interface{}) {
+	*l = append(*l, &ParseError{File: file, Line: line, Err: fmt.Errorf(format, args...)})
+}
+
+// Add a problem with a file as a whole
+func (l *ErrorList) addFile(file string, err error) {
+	if err != nil {
+		*l = append(*l, &ParseError{File: file, Err: err})
+	}
+}
+
+// The list as error, nil when there are no errors
+func (l ErrorList) err() error {
+	if len(l) == 0 {
+		return nil
+	}
+
+	return l
+}
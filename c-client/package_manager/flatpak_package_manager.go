@@ -0,0 +1,176 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// System wide installations of applications and runtimes, deployed at
+// <directory>/<id>/<arch>/<branch>/active
+const (
+	flatpakAppDirectory     = "/var/lib/flatpak/app"
+	flatpakRuntimeDirectory = "/var/lib/flatpak/runtime"
+)
+
+// The newest release comes first in the AppStream metadata
+var flatpakReleaseRegexp = regexp.MustCompile(`<release\b[^>]*\bversion="([^"]+)"`)
+
+type FlatpakPackageManagerImpl struct{}
+
+// Short name of the package manager
+func (FlatpakPackageManagerImpl) Id() string {
+	return "flatpak"
+}
+
+// Files needed by the manager: the current and active links and of the
+// deployments the AppStream metadata and the metadata file, which every
+// deployment has, so the directories the links point to exist in copies
+func (FlatpakPackageManagerImpl) FilesNeeded() []FileSpec {
+	var files []FileSpec
+	for _, directory := range []string{flatpakAppDirectory, flatpakRuntimeDirectory} {
+		for _, pattern := range []string{
+			"/*/current",
+			"/*/*/*/active",
+			"/*/*/*/*/metadata",
+			"/*/*/*/*/files/share/metainfo/*.xml",
+			"/*/*/*/*/files/share/appdata/*.xml",
+		} {
+			files = append(files, FileSpec{Path: directory + pattern, Kind: GlobPattern, Optional: true})
+		}
+	}
+
+	return files
+}
+
+// Get the packages installed in the file system
+func (FlatpakPackageManagerImpl) Get(fsys fs.FS) ([]Package, error) {
+	var packages []Package
+	var errs ErrorList
+
+	// Of an application only the current arch and branch is reported, if the
+	// current link can't be read all of them are
+	apps, err := fs.ReadDir(fsys, fsName(flatpakAppDirectory))
+	if !errors.Is(err, fs.ErrNotExist) {
+		errs.addFile(flatpakAppDirectory, err)
+	}
+
+	for _, app := range apps {
+		directory := path.Join(flatpakAppDirectory, app.Name())
+
+		current, err := readLink(fsys, path.Join(directory, "current"))
+		if err != nil {
+			deployments, err := readFlatpakDeployments(fsys, directory)
+			errs.add(err)
+
+			packages = append(packages, deployments...)
+			continue
+		}
+
+		split := strings.Split(path.Clean(current), "/")
+		if len(split) != 2 {
+			errs.addFile(path.Join(directory, "current"), fmt.Errorf("invalid link to %s", current))
+			continue
+		}
+
+		pkg, ok, err := readFlatpakDeployment(fsys, directory, split[0], split[1])
+		errs.add(err)
+		if ok {
+			packages = append(packages, pkg)
+		}
+	}
+
+	// Runtimes are often installed for several branches at once
+	runtimes, err := fs.ReadDir(fsys, fsName(flatpakRuntimeDirectory))
+	if !errors.Is(err, fs.ErrNotExist) {
+		errs.addFile(flatpakRuntimeDirectory, err)
+	}
+
+	for _, runtime := range runtimes {
+		deployments, err := readFlatpakDeployments(fsys, path.Join(flatpakRuntimeDirectory, runtime.Name()))
+		errs.add(err)
+
+		packages = append(packages, deployments...)
+	}
+
+	return packages, errs.err()
+}
+
+// Get the packages of all active deployments of an application or runtime
+func readFlatpakDeployments(fsys fs.FS, directory string) ([]Package, error) {
+	var packages []Package
+	var errs ErrorList
+
+	architectures, err := fs.ReadDir(fsys, fsName(directory))
+	errs.addFile(directory, err)
+
+	for _, architecture := range architectures {
+		if !architecture.IsDir() {
+			continue
+		}
+
+		architectureDirectory := path.Join(directory, architecture.Name())
+
+		branches, err := fs.ReadDir(fsys, fsName(architectureDirectory))
+		errs.addFile(architectureDirectory, err)
+
+		for _, branch := range branches {
+			pkg, ok, err := readFlatpakDeployment(fsys, directory, architecture.Name(), branch.Name())
+			errs.add(err)
+			if ok {
+				packages = append(packages, pkg)
+			}
+		}
+	}
+
+	return packages, errs.err()
+}
+
+// Get the package of an active deployment, the version is taken from the
+// AppStream metadata and is the branch when there is none
+func readFlatpakDeployment(fsys fs.FS, directory string, architecture string, branch string) (Package, bool, error) {
+	id := path.Base(directory)
+	active := path.Join(directory, architecture, branch, "active")
+
+	if _, err := fs.Stat(fsys, fsName(active)); err != nil {
+		return Package{}, false, nil
+	}
+
+	pkg := Package{
+		Name:         id,
+		Version:      branch,
+		Manager:      "flatpak",
+		Architecture: architecture,
+	}
+
+	for _, metadata := range []string{
+		"files/share/metainfo/" + id + ".metainfo.xml",
+		"files/share/metainfo/" + id + ".appdata.xml",
+		"files/share/appdata/" + id + ".appdata.xml",
+	} {
+		file := path.Join(active, metadata)
+
+		data, err := fs.ReadFile(fsys, fsName(file))
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				return pkg, true, &ParseError{File: file, Err: err}
+			}
+			continue
+		}
+
+		if match := flatpakReleaseRegexp.FindSubmatch(data); match != nil {
+			pkg.Version = string(match[1])
+			break
+		}
+	}
+
+	return pkg, true, nil
+}
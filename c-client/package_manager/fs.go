@@ -0,0 +1,286 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Package managers get the scanned file system as fs.FS, rooted at the root of
+// the scanned system. Paths in FileSpec and the names returned by Glob are
+// slash separated absolute paths like /var/lib/dpkg/status, fsName turns them
+// into names for the file system.
+
+// Implemented by file systems which can read symbolic links without following
+// them, like the file system returned by DirFS
+type SymlinkFS interface {
+	fs.FS
+
+	// Like fs.Stat, without following a final symbolic link
+	Lstat(name string) (fs.FileInfo, error)
+
+	// Get the target of a symbolic link
+	ReadLink(name string) (string, error)
+}
+
+// The file system below a directory of the running system. Symbolic links are
+// resolved within the directory, absolute targets and relative targets going
+// up beyond it are relative to it, like they are in a container. A copy of
+// the files of a container can't refer to files of the running system that
+// way.
+func DirFS(root string) fs.FS {
+	return dirFS{root: root}
+}
+
+type dirFS struct {
+	root string
+}
+
+func (d dirFS) Open(name string) (fs.File, error) {
+	location, err := d.locate("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(location)
+}
+
+func (d dirFS) Lstat(name string) (fs.FileInfo, error) {
+	location, err := d.locate("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Lstat(location)
+}
+
+func (d dirFS) ReadLink(name string) (string, error) {
+	location, err := d.locate("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+
+	return os.Readlink(location)
+}
+
+// Get the location of a name on the running system, with the symbolic links
+// in it resolved within the directory. A final symbolic link is only resolved
+// when follow is set. Below / that is what the running system does itself.
+func (d dirFS) locate(op string, name string, follow bool) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	if filepath.Clean(d.root) == string(filepath.Separator) {
+		return filepath.Join(d.root, filepath.FromSlash(name)), nil
+	}
+
+	location := filepath.Clean(d.root)
+	parts := strings.Split(name, "/")
+
+	for links := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if location != filepath.Clean(d.root) {
+				location = filepath.Dir(location)
+			}
+			continue
+		}
+
+		next := filepath.Join(location, part)
+		if len(parts) == 0 && !follow {
+			return next, nil
+		}
+
+		info, err := os.Lstat(next)
+		if err != nil {
+			// Nothing below a missing file exists either, the rest is not
+			// cleaned so it can't go up again
+			if len(parts) > 0 {
+				next += string(filepath.Separator) + filepath.FromSlash(strings.Join(parts, "/"))
+			}
+			return next, nil
+		}
+
+		if info.Mode()&fs.ModeSymlink == 0 {
+			location = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", &fs.PathError{Op: op, Path: name, Err: errTooManySymlinks}
+		}
+
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", &fs.PathError{Op: op, Path: name, Err: err}
+		}
+
+		if path.IsAbs(target) {
+			location = filepath.Clean(d.root)
+		}
+		parts = append(strings.Split(target, "/"), parts...)
+	}
+
+	return location, nil
+}
+
+// Get the name in a file system of a slash separated absolute path
+func fsName(file string) string {
+	name := strings.TrimPrefix(path.Clean("/"+file), "/")
+	if name == "" {
+		return "."
+	}
+
+	return name
+}
+
+// Get the target of a symbolic link, fails if the file system can't read
+// symbolic links
+func readLink(fsys fs.FS, file string) (string, error) {
+	symlinkFS, ok := fsys.(SymlinkFS)
+	if !ok {
+		return "", &fs.PathError{Op: "readlink", Path: file, Err: fs.ErrInvalid}
+	}
+
+	return symlinkFS.ReadLink(fsName(file))
+}
+
+// Like fs.Stat without following a final symbolic link, the same as fs.Stat if
+// the file system can't read symbolic links
+func lstat(fsys fs.FS, file string) (fs.FileInfo, error) {
+	if symlinkFS, ok := fsys.(SymlinkFS); ok {
+		return symlinkFS.Lstat(fsName(file))
+	}
+
+	return fs.Stat(fsys, fsName(file))
+}
+
+// The most symbolic links followed resolving a path, like Linux
+const maxSymlinks = 40
+
+var errTooManySymlinks = errors.New("too many levels of symbolic links")
+
+// Resolve the symbolic links in a slash separated absolute path within the
+// file system. Absolute targets and relative targets going up beyond the root
+// are relative to the root of the file system, like they are in a container.
+func evalSymlinks(fsys fs.FS, file string) (string, error) {
+	resolved, _, err := walkSymlinks(fsys, file)
+
+	return resolved, err
+}
+
+// Resolve the symbolic links in the leading part of a slash separated absolute
+// path which exists in the file system, like evalSymlinks. The rest of the
+// path is kept as is, a path which can't be resolved is returned unchanged.
+func ResolveSymlinks(fsys fs.FS, file string) string {
+	resolved, rest, err := walkSymlinks(fsys, file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return path.Clean("/" + file)
+	}
+
+	return path.Join(append([]string{resolved}, rest...)...)
+}
+
+// Resolve the symbolic links in a path as far as it exists, the parts of the
+// path which don't are returned with the error
+func walkSymlinks(fsys fs.FS, file string) (string, []string, error) {
+	parts := strings.Split(file, "/")
+	resolved := "/"
+
+	for links := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+
+		if part == "" || part == "." {
+			continue
+		}
+
+		next := path.Join(resolved, part)
+
+		info, err := lstat(fsys, next)
+		if err != nil {
+			return resolved, append([]string{part}, parts...), err
+		}
+
+		if info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return resolved, nil, &fs.PathError{Op: "readlink", Path: file, Err: errTooManySymlinks}
+		}
+
+		target, err := readLink(fsys, next)
+		if err != nil {
+			return resolved, nil, err
+		}
+
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		parts = append(strings.Split(target, "/"), parts...)
+	}
+
+	return resolved, nil, nil
+}
+
+// A file opened for random access, see openReaderAt
+type readerAtFile struct {
+	io.ReaderAt
+	Size  int64
+	close func() error
+}
+
+func (f readerAtFile) Close() error {
+	return f.close()
+}
+
+// Open a file for random access. Files which don't support it are read into
+// memory, up to maxSize bytes.
+func openReaderAt(fsys fs.FS, file string, maxSize int64) (readerAtFile, error) {
+	f, err := fsys.Open(fsName(file))
+	if err != nil {
+		return readerAtFile{}, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return readerAtFile{}, err
+	}
+
+	if readerAt, ok := f.(io.ReaderAt); ok {
+		return readerAtFile{ReaderAt: readerAt, Size: info.Size(), close: f.Close}, nil
+	}
+	defer f.Close()
+
+	if info.Size() > maxSize {
+		return readerAtFile{}, &fs.PathError{Op: "read", Path: file, Err: errFileTooLarge}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(f, maxSize))
+	if err != nil {
+		return readerAtFile{}, err
+	}
+
+	return readerAtFile{ReaderAt: bytes.NewReader(data), Size: int64(len(data)), close: func() error { return nil }}, nil
+}
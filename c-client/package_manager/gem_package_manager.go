@@ -0,0 +1,117 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Matches the assignments in a generated gemspec, like s.name = "rake".freeze
+// or s.licenses = ["MIT".freeze]
+var (
+	gemNameRegexp     = regexp.MustCompile(`(?m)^\s*\w+\.name\s*=\s*["']([^"']+)["']`)
+	gemVersionRegexp  = regexp.MustCompile(`(?m)^\s*\w+\.version\s*=\s*["']([^"']+)["']`)
+	gemLicenseRegexp  = regexp.MustCompile(`(?m)^\s*\w+\.licenses?\s*=\s*\[?([^\n\]]*)`)
+	gemLiteralRegexp  = regexp.MustCompile(`["']([^"']+)["']`)
+	gemFileNameRegexp = regexp.MustCompile(`^(.+)-([^-]+)\.gemspec$`)
+)
+
+// Finds the Ruby gems installed in the gem paths GemPaths (which may be glob
+// patterns) through their specifications.
+type GemPackageManagerImpl struct {
+	GemPaths []string
+}
+
+// Short name of the package manager
+func (GemPackageManagerImpl) Id() string {
+	return "gem"
+}
+
+// Files needed by the manager
+func (m GemPackageManagerImpl) FilesNeeded() []FileSpec {
+	var files []FileSpec
+	for _, pattern := range m.patterns() {
+		files = append(files, FileSpec{Path: pattern, Kind: GlobPattern, Optional: true})
+	}
+
+	return files
+}
+
+// Get the packages installed in the file system
+func (m GemPackageManagerImpl) Get(fsys fs.FS) ([]Package, error) {
+	var packages []Package
+	var errs ErrorList
+	seen := make(map[string]bool)
+
+	for _, pattern := range m.patterns() {
+		for _, file := range Glob(fsys, pattern) {
+			if seen[file] {
+				continue
+			}
+			seen[file] = true
+
+			data, err := fs.ReadFile(fsys, fsName(file))
+			if err != nil {
+				errs.add(err)
+				continue
+			}
+
+			pkg, ok := parseGemspec(data, file)
+			if ok {
+				packages = append(packages, pkg)
+			}
+		}
+	}
+
+	return packages, errs.err()
+}
+
+// Specifications of default gems live in specifications/default
+func (m GemPackageManagerImpl) patterns() []string {
+	var patterns []string
+	for _, gemPath := range m.GemPaths {
+		patterns = append(patterns, strings.TrimSuffix(gemPath, "/")+"/specifications/**/*.gemspec")
+	}
+
+	return patterns
+}
+
+// Get the name, version and licenses from a gemspec without evaluating it,
+// falling back to the file name for the name and version
+func parseGemspec(data []byte, file string) (Package, bool) {
+	content := string(data)
+
+	pkg := Package{
+		Manager: "gem",
+	}
+
+	if match := gemFileNameRegexp.FindStringSubmatch(path.Base(file)); match != nil {
+		pkg.Name = match[1]
+		pkg.Version = match[2]
+	}
+
+	if match := gemNameRegexp.FindStringSubmatch(content); match != nil {
+		pkg.Name = match[1]
+	}
+
+	if match := gemVersionRegexp.FindStringSubmatch(content); match != nil {
+		pkg.Version = match[1]
+	}
+
+	if match := gemLicenseRegexp.FindStringSubmatch(content); match != nil {
+		var licenses []string
+		for _, literal := range gemLiteralRegexp.FindAllStringSubmatch(match[1], -1) {
+			licenses = append(licenses, literal[1])
+		}
+
+		pkg.License = strings.Join(licenses, " OR ")
+	}
+
+	return pkg, pkg.Name != ""
+}
@@ -0,0 +1,132 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Glob patterns are slash separated absolute paths. Every element is matched
+// with path.Match, except for ** which matches zero or more elements.
+
+// Check if a slash separated path matches a glob pattern
+func MatchPath(pattern string, name string) bool {
+	return matchElements(splitPath(pattern), splitPath(name), false)
+}
+
+// Check if files below a directory could match a glob pattern
+func matchDirectory(pattern string, directory string) bool {
+	return matchElements(splitPath(pattern), splitPath(directory), true)
+}
+
+// The longest leading part of a glob pattern without any wildcards
+func GlobBase(pattern string) string {
+	var base []string
+
+	for _, element := range splitPath(pattern) {
+		if element == "**" || strings.ContainsAny(element, "*?[\\") {
+			break
+		}
+
+		base = append(base, element)
+	}
+
+	return "/" + strings.Join(base, "/")
+}
+
+// Find the regular files in the file system matching a glob pattern, the names
+// are slash separated absolute paths. Symbolic links are not followed.
+func Glob(fsys fs.FS, pattern string) []string {
+	return globFiles(fsys, FileSpec{Path: pattern, Kind: GlobPattern}, 0)
+}
+
+// Returned to stop walking once enough files are found
+var errGlobLimit = errors.New("enough files found")
+
+// Find the regular files of a glob pattern file spec like Glob, only in the
+// directories within its MaxDepth and at most its MaxFiles files. The search
+// stops after limit files as well, unless it is zero.
+func globFiles(fsys fs.FS, file FileSpec, limit int) []string {
+	if file.MaxFiles > 0 && (limit <= 0 || file.MaxFiles < limit) {
+		limit = file.MaxFiles
+	}
+
+	var matches []string
+
+	_ = fs.WalkDir(fsys, fsName(GlobBase(file.Path)), func(current string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		name := path.Join("/", current)
+
+		if entry.IsDir() {
+			if !matchDirectory(file.Path, name) || !file.withinDepth(name) {
+				return fs.SkipDir
+			}
+
+			return nil
+		}
+
+		if entry.Type().IsRegular() && file.Matches(name) {
+			matches = append(matches, name)
+
+			if limit > 0 && len(matches) >= limit {
+				return errGlobLimit
+			}
+		}
+
+		return nil
+	})
+
+	return matches
+}
+
+func splitPath(name string) []string {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return nil
+	}
+
+	return strings.Split(name, "/")
+}
+
+// Match path elements against pattern elements. With prefix set it is enough
+// for the elements to be the start of a match.
+func matchElements(pattern []string, name []string, prefix bool) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if prefix {
+				return true
+			}
+
+			for i := 0; i <= len(name); i++ {
+				if matchElements(pattern[1:], name[i:], prefix) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(name) == 0 {
+			return prefix
+		}
+
+		matched, err := path.Match(pattern[0], name[0])
+		if err != nil || !matched {
+			return false
+		}
+
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+
+	return len(name) == 0
+}
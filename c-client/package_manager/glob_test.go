@@ -0,0 +1,46 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+// The files of a glob pattern are only searched within the limits of the spec
+func TestGlobFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"opt/a/package.json":                    {},
+		"opt/a/node_modules/b/package.json":     {},
+		"opt/a/node_modules/b/c/d/package.json": {},
+		"opt/e/package.json":                    {},
+	}
+
+	for _, test := range []struct {
+		maxDepth int
+		maxFiles int
+		limit    int
+		expected []string
+	}{
+		{0, 0, 0, []string{"/opt/a/node_modules/b/c/d/package.json", "/opt/a/node_modules/b/package.json", "/opt/a/package.json", "/opt/e/package.json"}},
+		{3, 0, 0, []string{"/opt/a/node_modules/b/package.json", "/opt/a/package.json", "/opt/e/package.json"}},
+		{1, 0, 0, []string{"/opt/a/package.json", "/opt/e/package.json"}},
+		{3, 2, 0, []string{"/opt/a/node_modules/b/package.json", "/opt/a/package.json"}},
+		{0, 2, 1, []string{"/opt/a/node_modules/b/c/d/package.json"}},
+	} {
+		file := FileSpec{Path: "/opt/**/package.json", Kind: GlobPattern, MaxDepth: test.maxDepth, MaxFiles: test.maxFiles}
+
+		matches := globFiles(fsys, file, test.limit)
+		if !reflect.DeepEqual(matches, test.expected) {
+			t.Errorf("depth %d, files %d, limit %d: %v, expected %v", test.maxDepth, test.maxFiles, test.limit, matches, test.expected)
+		}
+	}
+
+	if Present(fsys, FileSpec{Path: "/opt/*/*/package.json", Kind: GlobPattern}) {
+		t.Errorf("/opt/*/*/package.json present")
+	}
+}
@@ -0,0 +1,350 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// Finds Go executables below Paths and reports the modules they were built
+// from, as recorded in the build info the Go linker embeds. Binaries are only
+// read, never executed.
+type GoModPackageManagerImpl struct {
+	Paths []string
+}
+
+// Short name of the package manager
+func (GoModPackageManagerImpl) Id() string {
+	return "gomod"
+}
+
+// Files needed by the manager
+func (m GoModPackageManagerImpl) FilesNeeded() []FileSpec {
+	return elfFilesNeeded(m.Paths)
+}
+
+// Get the packages installed in the file system
+func (m GoModPackageManagerImpl) Get(fsys fs.FS) ([]Package, error) {
+	var packages []Package
+
+	err := walkElfFiles(fsys, elfFilesNeeded(m.Paths), func(name string, elfFile *elf.File) error {
+		version, modInfo, err := readGoBuildInfo(elfFile)
+		if errors.Is(err, errNoGoBuildInfo) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// The toolchain the binary was built with is reported as stdlib
+		packages = append(packages, Package{
+			Name:     "stdlib",
+			Version:  version,
+			Manager:  "gomod",
+			Location: name,
+		})
+
+		for _, pkg := range parseGoModInfo(modInfo) {
+			pkg.Location = name
+			packages = append(packages, pkg)
+		}
+
+		return nil
+	})
+
+	return packages, err
+}
+
+// Glob patterns for the files below the paths that can be ELF binaries: the
+// files directly in a path and those in bin and sbin directories in it or one
+// level below, like /opt/<name>/bin. Matching every file, or searching bin
+// directories at any depth, would walk and copy whole directory trees of
+// containers and images.
+func elfFilesNeeded(paths []string) []FileSpec {
+	var files []FileSpec
+	for _, path := range paths {
+		path = strings.TrimSuffix(path, "/")
+		for _, pattern := range []string{"/*", "/bin/*", "/sbin/*", "/*/bin/*", "/*/sbin/*"} {
+			files = append(files, FileSpec{Path: path + pattern, Kind: GlobPattern, Optional: true})
+		}
+	}
+
+	return files
+}
+
+// Maximum size of a binary read into memory, for file systems which don't
+// support random access
+const elfMaxInMemorySize = 256 << 20
+
+// Call visit for every ELF executable or shared object matching the files,
+// see elfFilesNeeded, with its path in the scanned file system. Files which
+// are not ELF files are skipped, the error describes the files which can't be
+// read and the errors returned by visit.
+func walkElfFiles(fsys fs.FS, files []FileSpec, visit func(name string, elfFile *elf.File) error) error {
+	var errs ErrorList
+	seen := make(map[string]bool)
+
+	for _, file := range files {
+		for _, name := range Glob(fsys, file.Path) {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+
+			errs.addFile(name, visitElfFile(fsys, name, visit))
+		}
+	}
+
+	return errs.err()
+}
+
+func visitElfFile(fsys fs.FS, name string, visit func(name string, elfFile *elf.File) error) error {
+	isElf, err := isElfFile(fsys, name)
+	if err != nil || !isElf {
+		return err
+	}
+
+	f, err := openReaderAt(fsys, name, elfMaxInMemorySize)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	elfFile, err := elf.NewFile(f)
+	if err != nil {
+		return err
+	}
+
+	if elfFile.Type != elf.ET_EXEC && elfFile.Type != elf.ET_DYN {
+		return nil
+	}
+
+	return visit(name, elfFile)
+}
+
+// Check the magic before handing a file to debug/elf, files shorter than the
+// magic are no ELF files either
+func isElfFile(fsys fs.FS, file string) (bool, error) {
+	f, err := fsys.Open(fsName(file))
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	_, err = io.ReadFull(f, magic)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return string(magic) == elf.ELFMAG, nil
+}
+
+var goBuildInfoMagic = []byte("\xff Go buildinf:")
+
+// Binaries not built by Go have no build info
+var errNoGoBuildInfo = errors.New("no go build info")
+
+// Maximum size of the data searched for the build info when there is no
+// .go.buildinfo section
+const goBuildInfoSearchLimit = 64 << 20
+
+// Read the Go version and module info from the build info of a binary
+func readGoBuildInfo(elfFile *elf.File) (string, string, error) {
+	var data []byte
+
+	if section := elfFile.Section(".go.buildinfo"); section != nil {
+		data, _ = section.Data()
+	} else {
+		// Stripped section headers, the build info is at the start of a 16
+		// byte aligned block in the first writable segment
+		for _, prog := range elfFile.Progs {
+			if prog.Type == elf.PT_LOAD && prog.Flags&elf.PF_W != 0 {
+				size := prog.Filesz
+				if size > goBuildInfoSearchLimit {
+					size = goBuildInfoSearchLimit
+				}
+
+				data = make([]byte, size)
+				_, err := prog.ReadAt(data, 0)
+				if err != nil && err != io.EOF {
+					return "", "", err
+				}
+
+				break
+			}
+		}
+
+		for offset := 0; ; offset += 16 {
+			if offset >= len(data) {
+				return "", "", errNoGoBuildInfo
+			}
+
+			index := bytes.Index(data[offset:], goBuildInfoMagic)
+			if index < 0 {
+				return "", "", errNoGoBuildInfo
+			}
+
+			offset += index
+			if offset%16 == 0 {
+				data = data[offset:]
+				break
+			}
+
+			offset -= offset % 16
+		}
+	}
+
+	if len(data) < 32 || !bytes.HasPrefix(data, goBuildInfoMagic) {
+		return "", "", errNoGoBuildInfo
+	}
+
+	pointerSize := int(data[14])
+	flags := data[15]
+
+	var version, modInfo string
+
+	if flags&2 != 0 {
+		// Go 1.18 and up store the strings inline, prefixed by their length
+		var ok bool
+		rest := data[32:]
+
+		version, rest, ok = readGoVarintString(rest)
+		if !ok {
+			return "", "", fmt.Errorf("invalid go build info")
+		}
+
+		modInfo, _, ok = readGoVarintString(rest)
+		if !ok {
+			return "", "", fmt.Errorf("invalid go build info")
+		}
+	} else {
+		// Older versions store pointers to the runtime.buildVersion and
+		// runtime.modinfo string headers
+		var byteOrder binary.ByteOrder = binary.LittleEndian
+		if flags&1 != 0 {
+			byteOrder = binary.BigEndian
+		}
+
+		if (pointerSize != 4 && pointerSize != 8) || len(data) < 16+2*pointerSize {
+			return "", "", fmt.Errorf("invalid go build info")
+		}
+
+		readPointer := func(b []byte) uint64 {
+			if pointerSize == 4 {
+				return uint64(byteOrder.Uint32(b))
+			}
+
+			return byteOrder.Uint64(b)
+		}
+
+		readString := func(address uint64) string {
+			header := readElfMemory(elfFile, address, uint64(2*pointerSize))
+			if header == nil {
+				return ""
+			}
+
+			value := readElfMemory(elfFile, readPointer(header), readPointer(header[pointerSize:]))
+
+			return string(value)
+		}
+
+		version = readString(readPointer(data[16:]))
+		modInfo = readString(readPointer(data[16+pointerSize:]))
+	}
+
+	if version == "" {
+		return "", "", fmt.Errorf("no go version in build info")
+	}
+
+	// The module info is wrapped in 16 byte sentinels
+	if len(modInfo) >= 33 && modInfo[len(modInfo)-17] == '\n' {
+		modInfo = modInfo[16 : len(modInfo)-16]
+	} else {
+		modInfo = ""
+	}
+
+	return version, modInfo, nil
+}
+
+// Read a string prefixed by its length as a varint
+func readGoVarintString(data []byte) (string, []byte, bool) {
+	length, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < length {
+		return "", nil, false
+	}
+
+	return string(data[n : n+int(length)]), data[n+int(length):], true
+}
+
+// Read memory of the loaded binary at a virtual address
+func readElfMemory(elfFile *elf.File, address uint64, size uint64) []byte {
+	if size > goBuildInfoSearchLimit {
+		return nil
+	}
+
+	for _, prog := range elfFile.Progs {
+		if prog.Type != elf.PT_LOAD || address < prog.Vaddr || address+size > prog.Vaddr+prog.Filesz {
+			continue
+		}
+
+		data := make([]byte, size)
+		_, err := prog.ReadAt(data, int64(address-prog.Vaddr))
+		if err != nil && err != io.EOF {
+			return nil
+		}
+
+		return data
+	}
+
+	return nil
+}
+
+// Parse the module info into the main module and its dependencies. Lines
+// are tab separated, a "=>" line replaces the module before it.
+func parseGoModInfo(modInfo string) []Package {
+	var packages []Package
+
+	for _, line := range strings.Split(modInfo, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "mod", "dep":
+			version := ""
+			if len(fields) > 2 {
+				version = fields[2]
+			}
+
+			packages = append(packages, Package{
+				Name:    fields[1],
+				Version: version,
+				Manager: "gomod",
+			})
+		case "=>":
+			// Replacements by a local directory have no version, the original
+			// module is kept in that case
+			if len(packages) > 0 && len(fields) > 2 && fields[2] != "" {
+				packages[len(packages)-1].Name = fields[1]
+				packages[len(packages)-1].Version = fields[2]
+			}
+		}
+	}
+
+	return packages
+}
@@ -0,0 +1,283 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Reader for the LZ4 frame format, which apt uses for the lists it keeps
+// compressed. Blocks are decompressed one at a time, keeping the last 64 KiB
+// as the window of linked blocks. Checksums are skipped, not verified.
+
+const (
+	lz4FrameMagic        = 0x184d2204
+	lz4SkippableMagic    = 0x184d2a50
+	lz4SkippableMask     = 0xfffffff0
+	lz4Window            = 64 << 10
+	lz4FlagBlockChecksum = 0x10
+	lz4FlagContentSize   = 0x08
+	lz4FlagContentSum    = 0x04
+	lz4FlagDictionaryID  = 0x01
+	lz4BlockUncompressed = 0x80000000
+)
+
+type lz4Reader struct {
+	reader *bufio.Reader
+	flags  byte
+	// The maximum decompressed size of a block of the current frame
+	blockSize int
+	window    []byte
+	pending   []byte
+	done      bool
+}
+
+func newLz4Reader(reader io.Reader) (io.Reader, error) {
+	r := &lz4Reader{reader: bufio.NewReader(reader)}
+
+	err := r.readFrameHeader(true)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *lz4Reader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		err := r.readBlock()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+
+	return n, nil
+}
+
+// Read the header of the next frame, skipping skippable frames. Without more
+// frames the reader is done, unless the first frame is expected.
+func (r *lz4Reader) readFrameHeader(first bool) error {
+	for {
+		var magic [4]byte
+		_, err := io.ReadFull(r.reader, magic[:])
+		if err == io.EOF && !first {
+			r.done = true
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid lz4 frame: %v", err)
+		}
+
+		switch value := binary.LittleEndian.Uint32(magic[:]); {
+		case value == lz4FrameMagic:
+		case value&lz4SkippableMask == lz4SkippableMagic:
+			var size [4]byte
+			_, err = io.ReadFull(r.reader, size[:])
+			if err != nil {
+				return fmt.Errorf("invalid lz4 frame: %v", err)
+			}
+
+			err = r.skip(int(binary.LittleEndian.Uint32(size[:])))
+			if err != nil {
+				return err
+			}
+			continue
+		default:
+			return fmt.Errorf("not an lz4 frame")
+		}
+
+		break
+	}
+
+	var descriptor [2]byte
+	_, err := io.ReadFull(r.reader, descriptor[:])
+	if err != nil {
+		return fmt.Errorf("invalid lz4 frame: %v", err)
+	}
+
+	r.flags = descriptor[0]
+	if r.flags>>6 != 1 {
+		return fmt.Errorf("unsupported lz4 frame version %d", r.flags>>6)
+	}
+
+	// 64 KiB, 256 KiB, 1 MiB or 4 MiB
+	sizeID := int(descriptor[1]>>4) & 7
+	if sizeID < 4 {
+		return fmt.Errorf("invalid lz4 block size %d", sizeID)
+	}
+	r.blockSize = 1 << (8 + 2*sizeID)
+
+	// The content size, dictionary ID and the header checksum
+	skip := 1
+	if r.flags&lz4FlagContentSize != 0 {
+		skip += 8
+	}
+	if r.flags&lz4FlagDictionaryID != 0 {
+		return fmt.Errorf("lz4 dictionaries are not supported")
+	}
+
+	return r.skip(skip)
+}
+
+// Decompress the next block into the window
+func (r *lz4Reader) readBlock() error {
+	var header [4]byte
+	_, err := io.ReadFull(r.reader, header[:])
+	if err != nil {
+		return fmt.Errorf("invalid lz4 block: %v", err)
+	}
+
+	size := binary.LittleEndian.Uint32(header[:])
+	if size == 0 {
+		// End of the frame
+		if r.flags&lz4FlagContentSum != 0 {
+			err = r.skip(4)
+			if err != nil {
+				return err
+			}
+		}
+
+		return r.readFrameHeader(false)
+	}
+
+	uncompressed := size&lz4BlockUncompressed != 0
+	size &^= lz4BlockUncompressed
+	if int(size) > r.blockSize {
+		return fmt.Errorf("lz4 block of %d bytes exceeds block size %d", size, r.blockSize)
+	}
+
+	data := make([]byte, size)
+	_, err = io.ReadFull(r.reader, data)
+	if err != nil {
+		return fmt.Errorf("invalid lz4 block: %v", err)
+	}
+
+	if r.flags&lz4FlagBlockChecksum != 0 {
+		err = r.skip(4)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Only the last 64 KiB can be referred to by the next block
+	if len(r.window) > lz4Window {
+		r.window = append(r.window[:0], r.window[len(r.window)-lz4Window:]...)
+	}
+
+	start := len(r.window)
+	if uncompressed {
+		r.window = append(r.window, data...)
+	} else {
+		r.window, err = decodeLz4Block(r.window, data, r.blockSize)
+		if err != nil {
+			return err
+		}
+	}
+	r.pending = r.window[start:]
+
+	return nil
+}
+
+func (r *lz4Reader) skip(n int) error {
+	_, err := r.reader.Discard(n)
+	if err != nil {
+		return fmt.Errorf("invalid lz4 frame: %v", err)
+	}
+
+	return nil
+}
+
+// Decompress a block, appending it to dst which holds the data matches may
+// refer to. Every sequence is literals followed by a match, except for the
+// last one which only has literals.
+func decodeLz4Block(dst []byte, src []byte, maxSize int) ([]byte, error) {
+	start := len(dst)
+
+	for i := 0; i < len(src); {
+		token := src[i]
+		i++
+
+		literals, n, err := lz4Length(int(token>>4), src[i:])
+		if err != nil {
+			return nil, err
+		}
+		i += n
+
+		if literals > len(src)-i {
+			return nil, fmt.Errorf("lz4 literals exceed the block")
+		}
+		dst = append(dst, src[i:i+literals]...)
+		i += literals
+
+		if i == len(src) {
+			break
+		}
+
+		if i+2 > len(src) {
+			return nil, fmt.Errorf("lz4 match offset exceeds the block")
+		}
+		offset := int(binary.LittleEndian.Uint16(src[i:]))
+		i += 2
+
+		if offset == 0 || offset > len(dst) {
+			return nil, fmt.Errorf("invalid lz4 match offset %d", offset)
+		}
+
+		length, n, err := lz4Length(int(token&0xf), src[i:])
+		if err != nil {
+			return nil, err
+		}
+		i += n
+		length += 4
+
+		if len(dst)-start+length > maxSize {
+			return nil, fmt.Errorf("lz4 block exceeds block size %d", maxSize)
+		}
+
+		// A match can overlap the data it produces, repeating it
+		from := len(dst) - offset
+		if length <= offset {
+			dst = append(dst, dst[from:from+length]...)
+		} else {
+			for j := 0; j < length; j++ {
+				dst = append(dst, dst[from+j])
+			}
+		}
+	}
+
+	if len(dst)-start > maxSize {
+		return nil, fmt.Errorf("lz4 block exceeds block size %d", maxSize)
+	}
+
+	return dst, nil
+}
+
+// Get a literal or match length, which continues in the following bytes when
+// the 4 bits of the token are all set. Returns the number of bytes used.
+func lz4Length(length int, src []byte) (int, int, error) {
+	if length != 0xf {
+		return length, 0, nil
+	}
+
+	for i, b := range src {
+		length += int(b)
+		if b != 0xff {
+			return length, i + 1, nil
+		}
+	}
+
+	return 0, 0, fmt.Errorf("lz4 length exceeds the block")
+}
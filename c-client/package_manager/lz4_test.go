@@ -0,0 +1,47 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The first 200000 bytes of debian-status compressed with lz4 -9 -BD -B4 -BX,
+// linked 64 KiB blocks with block checksums
+func TestLz4Reader(t *testing.T) {
+	expected, err := os.ReadFile(filepath.Join("..", "testdata", "debian-status"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = expected[:200000]
+
+	compressed, err := os.ReadFile(filepath.Join("..", "testdata", "debian-status-200k.lz4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := newLz4Reader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data, expected) {
+		t.Errorf("decompressed %d bytes differ from the %d bytes expected", len(data), len(expected))
+	}
+
+	if _, err = newLz4Reader(bytes.NewReader(expected)); err == nil {
+		t.Errorf("no error for data which is not lz4")
+	}
+}
@@ -0,0 +1,276 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Limits for archives inside archives
+const (
+	mavenMaxNesting    = 4
+	mavenMaxNestedSize = 256 << 20
+)
+
+// Extensions of the archives that are opened, and a pattern for them
+var mavenArchiveExtensions = []string{".jar", ".war", ".ear"}
+
+const mavenArchivePattern = "/**/*.[jwe]ar"
+
+// Splits archive file names like log4j-core-2.14.1.jar into artifact and
+// version
+var mavenFileNameRegexp = regexp.MustCompile(`^(.+?)-(\d[^-]*(?:-[A-Za-z0-9.]+)?)$`)
+
+// Finds Java archives below Paths, including archives nested in them, and
+// reports the Maven artifacts they contain. Artifacts are taken from the
+// pom.properties files Maven puts in the archive, archives without any are
+// reported based on their manifest.
+type MavenPackageManagerImpl struct {
+	Paths []string
+}
+
+// Short name of the package manager
+func (MavenPackageManagerImpl) Id() string {
+	return "maven"
+}
+
+// Files needed by the manager
+func (m MavenPackageManagerImpl) FilesNeeded() []FileSpec {
+	var files []FileSpec
+	for _, path := range m.Paths {
+		files = append(files, FileSpec{Path: strings.TrimSuffix(path, "/") + mavenArchivePattern, Kind: GlobPattern, Optional: true})
+	}
+
+	return files
+}
+
+// Get the packages installed in the file system
+func (m MavenPackageManagerImpl) Get(fsys fs.FS) ([]Package, error) {
+	var packages []Package
+	var errs ErrorList
+	seen := make(map[string]bool)
+
+	for _, path := range m.Paths {
+		for _, file := range Glob(fsys, strings.TrimSuffix(path, "/")+mavenArchivePattern) {
+			if seen[file] {
+				continue
+			}
+			seen[file] = true
+
+			archivePackages, err := readMavenArchiveFile(fsys, file)
+			packages = append(packages, archivePackages...)
+			errs.add(err)
+		}
+	}
+
+	return packages, errs.err()
+}
+
+// Read the artifacts in an archive in the file system, nested archives which
+// can't be read are skipped
+func readMavenArchiveFile(fsys fs.FS, name string) ([]Package, error) {
+	file, err := openReaderAt(fsys, name, mavenMaxNestedSize)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	archive, err := zip.NewReader(file, file.Size)
+	if err != nil {
+		return nil, &ParseError{File: name, Err: err}
+	}
+
+	return readMavenArchive(archive, name, 0), nil
+}
+
+// Read the artifacts in an archive and the archives nested in it. Nested
+// archives are named <outer>!/<path in outer>.
+func readMavenArchive(archive *zip.Reader, name string, nesting int) []Package {
+	var packages []Package
+	var manifest map[string]string
+
+	for _, entry := range archive.File {
+		switch {
+		case strings.HasPrefix(entry.Name, "META-INF/maven/") && path.Base(entry.Name) == "pom.properties":
+			properties := readMavenProperties(entry)
+
+			if properties["artifactId"] == "" || properties["version"] == "" {
+				continue
+			}
+
+			packages = append(packages, Package{
+				Name:     mavenName(properties["groupId"], properties["artifactId"]),
+				Version:  properties["version"],
+				Manager:  "maven",
+				Location: name,
+			})
+		case entry.Name == "META-INF/MANIFEST.MF":
+			manifest = readMavenManifest(entry)
+		case isMavenArchive(entry.Name):
+			if nesting >= mavenMaxNesting || entry.UncompressedSize64 > mavenMaxNestedSize {
+				continue
+			}
+
+			nested, err := readZipEntry(entry)
+			if err != nil {
+				continue
+			}
+
+			nestedArchive, err := zip.NewReader(bytes.NewReader(nested), int64(len(nested)))
+			if err != nil {
+				continue
+			}
+
+			packages = append(packages, readMavenArchive(nestedArchive, name+"!/"+entry.Name, nesting+1)...)
+		}
+	}
+
+	// Shaded archives contain pom.properties of their own artifact as well as
+	// of the bundled ones, the manifest is only used when there are none
+	for _, pkg := range packages {
+		if pkg.Location == name {
+			return packages
+		}
+	}
+
+	if pkg, ok := mavenManifestPackage(manifest, name); ok {
+		packages = append(packages, pkg)
+	}
+
+	return packages
+}
+
+func isMavenArchive(name string) bool {
+	for _, extension := range mavenArchiveExtensions {
+		if strings.HasSuffix(strings.ToLower(name), extension) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func mavenName(groupId string, artifactId string) string {
+	if groupId == "" {
+		return artifactId
+	}
+
+	return groupId + ":" + artifactId
+}
+
+// Construct a package from the manifest, falling back to the file name of the
+// archive for the artifact and version
+func mavenManifestPackage(manifest map[string]string, name string) (Package, bool) {
+	base := path.Base(name)
+	base = base[:len(base)-len(path.Ext(base))]
+
+	artifactId := base
+	version := ""
+	if match := mavenFileNameRegexp.FindStringSubmatch(base); match != nil {
+		artifactId = match[1]
+		version = match[2]
+	}
+
+	for _, key := range []string{"Implementation-Version", "Bundle-Version", "Specification-Version"} {
+		if manifest[key] != "" {
+			version = manifest[key]
+			break
+		}
+	}
+
+	if version == "" {
+		return Package{}, false
+	}
+
+	return Package{
+		Name:     mavenName(manifest["Implementation-Vendor-Id"], artifactId),
+		Version:  version,
+		Manager:  "maven",
+		Location: name,
+	}, true
+}
+
+// Read a properties file, only the simple key=value form used in
+// pom.properties is supported
+func readMavenProperties(entry *zip.File) map[string]string {
+	properties := make(map[string]string)
+
+	data, err := readZipEntry(entry)
+	if err != nil {
+		return properties
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+
+		split := strings.SplitN(line, "=", 2)
+		if len(split) == 2 {
+			properties[strings.TrimSpace(split[0])] = strings.TrimSpace(split[1])
+		}
+	}
+
+	return properties
+}
+
+// Read the main section of a manifest, where long values continue on lines
+// starting with a space
+func readMavenManifest(entry *zip.File) map[string]string {
+	manifest := make(map[string]string)
+
+	data, err := readZipEntry(entry)
+	if err != nil {
+		return manifest
+	}
+
+	var key string
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		// The main section ends at the first empty line
+		if line == "" {
+			break
+		}
+
+		if strings.HasPrefix(line, " ") {
+			if key != "" {
+				manifest[key] += line[1:]
+			}
+
+			continue
+		}
+
+		split := strings.SplitN(line, ":", 2)
+		if len(split) == 2 {
+			key = strings.TrimSpace(split[0])
+			manifest[key] = strings.TrimSpace(split[1])
+		}
+	}
+
+	return manifest
+}
+
+func readZipEntry(entry *zip.File) ([]byte, error) {
+	reader, err := entry.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(io.LimitReader(reader, mavenMaxNestedSize))
+}
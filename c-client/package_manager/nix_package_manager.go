@@ -0,0 +1,208 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+)
+
+// The Nix database holds all valid store paths, the manifests of profiles
+// only those installed with nix profile
+const (
+	nixDatabaseFile        = "/nix/var/nix/db/db.sqlite"
+	nixDefaultManifest     = "/nix/var/nix/profiles/default/manifest.json"
+	nixPerUserProfiles     = "/nix/var/nix/profiles/per-user"
+	nixStorePathHashLength = 32
+)
+
+// Store paths which are not packages, like derivations, patches and sources
+var nixSkipRegexp = regexp.MustCompile(`\.(drv|patch|diff|tar(\.\w+)?|tgz|zip)$`)
+
+type NixPackageManagerImpl struct{}
+
+// Short name of the package manager
+func (NixPackageManagerImpl) Id() string {
+	return "nix"
+}
+
+// Files needed by the manager
+func (NixPackageManagerImpl) FilesNeeded() []FileSpec {
+	return []FileSpec{
+		{Path: nixDatabaseFile, Optional: true},
+		{Path: nixDatabaseFile + "-wal", Optional: true},
+		{Path: nixDefaultManifest, Optional: true},
+	}
+}
+
+// Get the packages installed in the file system
+func (NixPackageManagerImpl) Get(fsys fs.FS) ([]Package, error) {
+	if Present(fsys, FileSpec{Path: nixDatabaseFile}) {
+		return readNixDatabase(fsys, nixDatabaseFile)
+	}
+
+	manifests := []string{nixDefaultManifest}
+	users, _ := fs.ReadDir(fsys, fsName(nixPerUserProfiles))
+	for _, user := range users {
+		manifests = append(manifests, path.Join(nixPerUserProfiles, user.Name(), "profile", "manifest.json"))
+	}
+
+	var packages []Package
+	var errs ErrorList
+	seen := make(map[string]bool)
+
+	for _, manifest := range manifests {
+		storePaths, err := readNixManifest(fsys, manifest)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		errs.add(err)
+
+		for _, storePath := range storePaths {
+			if seen[storePath] {
+				continue
+			}
+			seen[storePath] = true
+
+			if pkg, ok := nixStorePathPackage(storePath); ok {
+				packages = append(packages, pkg)
+			}
+		}
+	}
+
+	return packages, errs.err()
+}
+
+// Get the packages of the valid paths in the database
+func readNixDatabase(fsys fs.FS, file string) ([]Package, error) {
+	data, err := fs.ReadFile(fsys, fsName(file))
+	if err != nil {
+		return nil, err
+	}
+
+	var errs ErrorList
+
+	data, err = applySqliteWalFile(fsys, file, data)
+	errs.addFile(file+"-wal", err)
+
+	packages, err := readNixValidPaths(data)
+	errs.addFile(file, err)
+
+	sort.Slice(packages, func(i, j int) bool {
+		return packages[i].Location < packages[j].Location
+	})
+
+	return packages, errs.err()
+}
+
+// Walk the ValidPaths table, with columns id, path, hash and registrationTime
+func readNixValidPaths(data []byte) ([]Package, error) {
+	db, err := openSqliteDatabase(data)
+	if err != nil {
+		return nil, err
+	}
+
+	rootPage, err := db.tableRootPage("ValidPaths")
+	if err != nil {
+		return nil, err
+	}
+
+	var packages []Package
+	err = db.walkTable(rootPage, func(record []interface{}) {
+		if len(record) < 4 {
+			return
+		}
+
+		storePath, _ := record[1].(string)
+		pkg, ok := nixStorePathPackage(storePath)
+		if !ok {
+			return
+		}
+
+		pkg.InstallTime, _ = record[3].(int64)
+		packages = append(packages, pkg)
+	})
+
+	return packages, err
+}
+
+type nixManifest struct {
+	Elements json.RawMessage `json:"elements"`
+}
+
+type nixManifestElement struct {
+	StorePaths []string `json:"storePaths"`
+}
+
+// Get the store paths of a profile manifest, the elements are a list up to
+// version 2 and keyed by name since version 3
+func readNixManifest(fsys fs.FS, file string) ([]string, error) {
+	data, err := fs.ReadFile(fsys, fsName(file))
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest nixManifest
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return nil, &ParseError{File: file, Err: err}
+	}
+
+	var elements []nixManifestElement
+	if json.Unmarshal(manifest.Elements, &elements) != nil {
+		var namedElements map[string]nixManifestElement
+		err = json.Unmarshal(manifest.Elements, &namedElements)
+		if err != nil {
+			return nil, &ParseError{File: file, Err: err}
+		}
+
+		var names []string
+		for name := range namedElements {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			elements = append(elements, namedElements[name])
+		}
+	}
+
+	var storePaths []string
+	for _, element := range elements {
+		storePaths = append(storePaths, element.StorePaths...)
+	}
+
+	return storePaths, nil
+}
+
+// Split a store path like /nix/store/<hash>-hello-2.10 into the name and the
+// version the way builtins.parseDrvName does: the version starts at the first
+// dash not followed by a letter. Paths without a version are not packages.
+func nixStorePathPackage(storePath string) (Package, bool) {
+	base := path.Base(storePath)
+	if len(base) <= nixStorePathHashLength+1 || base[nixStorePathHashLength] != '-' || nixSkipRegexp.MatchString(base) {
+		return Package{}, false
+	}
+
+	name := base[nixStorePathHashLength+1:]
+	for i := 0; i+1 < len(name); i++ {
+		next := name[i+1]
+		if name[i] == '-' && !(next >= 'a' && next <= 'z' || next >= 'A' && next <= 'Z') {
+			return Package{
+				Name:     name[:i],
+				Version:  name[i+1:],
+				Manager:  "nix",
+				Location: storePath,
+			}, i > 0
+		}
+	}
+
+	return Package{}, false
+}
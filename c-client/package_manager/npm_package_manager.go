@@ -0,0 +1,429 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Limits used when not configured
+const (
+	NpmDefaultMaxDepth = 16
+	NpmDefaultMaxFiles = 50000
+)
+
+var errNpmMaxFiles = errors.New("maximum number of files reached")
+
+// Finds the node packages installed in node_modules directories below Roots.
+// Lock files of projects are read as well, packages they list that are not
+// installed in node_modules next to them are reported from the lock file.
+// Walking stops at MaxDepth directories below a root and after reading
+// MaxFiles files.
+type NpmPackageManagerImpl struct {
+	Roots    []string
+	MaxDepth int
+	MaxFiles int
+}
+
+// Short name of the package manager
+func (NpmPackageManagerImpl) Id() string {
+	return "npm"
+}
+
+// Files needed by the manager, with the same limits as walking the roots
+func (m NpmPackageManagerImpl) FilesNeeded() []FileSpec {
+	maxDepth, maxFiles := m.limits()
+
+	var files []FileSpec
+	for _, root := range m.Roots {
+		root = strings.TrimSuffix(root, "/")
+
+		for _, name := range []string{"package.json", "package-lock.json", "yarn.lock"} {
+			files = append(files, FileSpec{
+				Path:     root + "/**/" + name,
+				Kind:     GlobPattern,
+				Optional: true,
+				MaxDepth: maxDepth,
+				MaxFiles: maxFiles,
+			})
+		}
+	}
+
+	return files
+}
+
+// Get the configured limits or the defaults
+func (m NpmPackageManagerImpl) limits() (int, int) {
+	maxDepth := m.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = NpmDefaultMaxDepth
+	}
+
+	maxFiles := m.MaxFiles
+	if maxFiles <= 0 {
+		maxFiles = NpmDefaultMaxFiles
+	}
+
+	return maxDepth, maxFiles
+}
+
+// Get the packages installed in the file system
+func (m NpmPackageManagerImpl) Get(fsys fs.FS) ([]Package, error) {
+	maxDepth, maxFiles := m.limits()
+
+	var packages []Package
+	var errs ErrorList
+	var lockFiles []string
+	files := 0
+
+	// Installed locations and name@version per project directory, used to skip
+	// lock file entries that are already reported from node_modules
+	installedLocations := make(map[string]bool)
+	installed := make(map[string]map[string]bool)
+
+	for _, searchRoot := range m.Roots {
+		base := path.Join("/", searchRoot)
+
+		err := fs.WalkDir(fsys, fsName(base), func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+
+			name := path.Join("/", file)
+
+			if entry.IsDir() {
+				relative := strings.TrimPrefix(strings.TrimPrefix(name, base), "/")
+				if relative != "" && strings.Count(relative, "/")+1 > maxDepth {
+					return fs.SkipDir
+				}
+
+				return nil
+			}
+
+			switch entry.Name() {
+			case "package.json":
+				project, ok := npmProjectDirectory(name)
+				if !ok {
+					return nil
+				}
+
+				files++
+				if files > maxFiles {
+					return errNpmMaxFiles
+				}
+
+				pkg, err := readNpmPackageJson(fsys, name)
+				if err != nil {
+					errs.add(err)
+					return nil
+				}
+				if pkg.Name == "" {
+					return nil
+				}
+				pkg.Location = name
+
+				packages = append(packages, pkg)
+
+				installedLocations[name] = true
+				if installed[project] == nil {
+					installed[project] = make(map[string]bool)
+				}
+				installed[project][pkg.Name+"@"+pkg.Version] = true
+			case "package-lock.json", "yarn.lock":
+				// Lock files shipped inside installed packages are not used
+				if strings.Contains(name, "/node_modules/") {
+					return nil
+				}
+
+				files++
+				if files > maxFiles {
+					return errNpmMaxFiles
+				}
+
+				lockFiles = append(lockFiles, name)
+			}
+
+			return nil
+		})
+		if err == errNpmMaxFiles {
+			errs.add(fmt.Errorf("stopped looking for node packages after %d files: %w", maxFiles, err))
+			break
+		}
+	}
+
+	for _, lockFile := range lockFiles {
+		entries, err := readNpmLockFile(fsys, lockFile)
+		errs.add(err)
+
+		project := path.Dir(lockFile)
+
+		for _, entry := range entries {
+			if entry.Path != "" {
+				if installedLocations[path.Join(project, entry.Path, "package.json")] {
+					continue
+				}
+			} else if installed[project][entry.Package.Name+"@"+entry.Package.Version] {
+				continue
+			}
+
+			entry.Package.Location = lockFile
+			packages = append(packages, entry.Package)
+		}
+	}
+
+	return packages, errs.err()
+}
+
+// Check if a package.json belongs to an installed package, which is the case
+// for node_modules/<name>/package.json and node_modules/@<scope>/<name>/package.json.
+// Returns the directory of the project the node_modules belongs to.
+func npmProjectDirectory(name string) (string, bool) {
+	elements := strings.Split(name, "/")
+	if len(elements) < 4 {
+		return "", false
+	}
+
+	packageDirectory := len(elements) - 2
+	if strings.HasPrefix(elements[packageDirectory-1], "@") {
+		packageDirectory--
+	}
+
+	if packageDirectory < 1 || elements[packageDirectory-1] != "node_modules" {
+		return "", false
+	}
+
+	// Nested node_modules belong to the top most project
+	index := strings.Index(name, "/node_modules/")
+	project := name[:index]
+	if project == "" {
+		project = "/"
+	}
+
+	return project, true
+}
+
+type npmPackageJson struct {
+	Name    string          `json:"name"`
+	Version string          `json:"version"`
+	License json.RawMessage `json:"license"`
+}
+
+// Read a package.json, packages without a name are returned as is
+func readNpmPackageJson(fsys fs.FS, file string) (Package, error) {
+	f, err := fsys.Open(fsName(file))
+	if err != nil {
+		return Package{}, err
+	}
+	defer f.Close()
+
+	var packageJson npmPackageJson
+	err = json.NewDecoder(f).Decode(&packageJson)
+	if err != nil {
+		return Package{}, &ParseError{File: file, Err: err}
+	}
+
+	return Package{
+		Name:    packageJson.Name,
+		Version: packageJson.Version,
+		Manager: "npm",
+		License: npmLicense(packageJson.License),
+	}, nil
+}
+
+// The license is either an SPDX expression or a (deprecated) object
+func npmLicense(raw json.RawMessage) string {
+	var expression string
+	if json.Unmarshal(raw, &expression) == nil {
+		return expression
+	}
+
+	var license struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(raw, &license) == nil {
+		return license.Type
+	}
+
+	return ""
+}
+
+// A package from a lock file, with its location relative to the project if
+// the lock file records it
+type npmLockEntry struct {
+	Package Package
+	Path    string
+}
+
+type npmPackageLockDependency struct {
+	Version      string                              `json:"version"`
+	Dependencies map[string]npmPackageLockDependency `json:"dependencies"`
+}
+
+type npmPackageLock struct {
+	// Lock file version 2 and up
+	Packages map[string]struct {
+		Name    string          `json:"name"`
+		Version string          `json:"version"`
+		License json.RawMessage `json:"license"`
+		Link    bool            `json:"link"`
+	} `json:"packages"`
+
+	// Lock file version 1
+	Dependencies map[string]npmPackageLockDependency `json:"dependencies"`
+}
+
+// Open and parse a package-lock.json or yarn.lock
+func readNpmLockFile(fsys fs.FS, file string) ([]npmLockEntry, error) {
+	f, err := fsys.Open(fsName(file))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if path.Base(file) == "yarn.lock" {
+		return parseYarnLock(f, file)
+	}
+
+	return parseNpmPackageLock(f, file)
+}
+
+// Parse a package-lock.json, the name of the file is used in the returned
+// error
+func parseNpmPackageLock(reader io.Reader, name string) ([]npmLockEntry, error) {
+	var lock npmPackageLock
+	err := json.NewDecoder(reader).Decode(&lock)
+	if err != nil {
+		return nil, &ParseError{File: name, Err: err}
+	}
+
+	var entries []npmLockEntry
+
+	if lock.Packages != nil {
+		// Keys are the install locations, the project itself has an empty key
+		var locations []string
+		for location := range lock.Packages {
+			locations = append(locations, location)
+		}
+		sort.Strings(locations)
+
+		for _, location := range locations {
+			lockPackage := lock.Packages[location]
+
+			index := strings.LastIndex(location, "node_modules/")
+			if index < 0 || lockPackage.Link {
+				continue
+			}
+
+			name := lockPackage.Name
+			if name == "" {
+				name = location[index+len("node_modules/"):]
+			}
+
+			entries = append(entries, npmLockEntry{
+				Package: Package{
+					Name:    name,
+					Version: lockPackage.Version,
+					Manager: "npm",
+					License: npmLicense(lockPackage.License),
+				},
+				Path: location,
+			})
+		}
+
+		return entries, nil
+	}
+
+	// Nested dependencies are different versions installed below the package
+	// depending on them, so every level is reported
+	var collect func(dependencies map[string]npmPackageLockDependency)
+	collect = func(dependencies map[string]npmPackageLockDependency) {
+		var names []string
+		for name := range dependencies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			entries = append(entries, npmLockEntry{
+				Package: Package{
+					Name:    name,
+					Version: dependencies[name].Version,
+					Manager: "npm",
+				},
+			})
+
+			collect(dependencies[name].Dependencies)
+		}
+	}
+	collect(lock.Dependencies)
+
+	return entries, nil
+}
+
+// Parse a yarn.lock, both the classic format and the YAML format of newer
+// versions. Entries start with an unindented list of specifiers and have an
+// indented version field. The name of the file is used in the returned error.
+func parseYarnLock(reader io.Reader, fileName string) ([]npmLockEntry, error) {
+	bufferedReader := bufio.NewReader(reader)
+
+	var entries []npmLockEntry
+	var name string
+
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := bufferedReader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return entries, &ParseError{File: fileName, Line: lineNumber, Err: err}
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+
+		if line != "" && !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "#") && strings.HasSuffix(line, ":") {
+			name = yarnLockName(strings.TrimSuffix(line, ":"))
+		} else if name != "" && strings.HasPrefix(line, "  version") {
+			version := strings.TrimPrefix(strings.TrimSpace(line), "version")
+			version = strings.Trim(strings.TrimPrefix(strings.TrimSpace(version), ":"), " \"")
+
+			entries = append(entries, npmLockEntry{
+				Package: Package{
+					Name:    name,
+					Version: version,
+					Manager: "npm",
+				},
+			})
+
+			name = ""
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	return entries, nil
+}
+
+// Get the package name from the first specifier of a yarn.lock entry, like
+// "@babel/core@^7.0.0" or "lodash@npm:^4.17.0"
+func yarnLockName(specifiers string) string {
+	specifier := strings.TrimSpace(strings.Split(specifiers, ",")[0])
+	specifier = strings.Trim(specifier, "\"")
+
+	// Entries without a version range, like __metadata, are no packages
+	index := strings.LastIndex(specifier, "@")
+	if index <= 0 {
+		return ""
+	}
+
+	return specifier[:index]
+}
@@ -0,0 +1,107 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// Locations of the status file, older versions keep it in /var/lib
+var opkgStatusFiles = []string{
+	"/usr/lib/opkg/status",
+	"/var/lib/opkg/status",
+}
+
+type OpkgPackageManagerImpl struct{}
+
+// Short name of the package manager
+func (OpkgPackageManagerImpl) Id() string {
+	return "opkg"
+}
+
+// Files needed by the manager
+func (OpkgPackageManagerImpl) FilesNeeded() []FileSpec {
+	var files []FileSpec
+	for _, statusFile := range opkgStatusFiles {
+		files = append(files, FileSpec{Path: statusFile, Optional: true})
+	}
+
+	return files
+}
+
+// Get the packages installed in the file system
+func (OpkgPackageManagerImpl) Get(fsys fs.FS) ([]Package, error) {
+	for _, statusFile := range opkgStatusFiles {
+		if !Present(fsys, FileSpec{Path: statusFile}) {
+			continue
+		}
+
+		file, err := fsys.Open(fsName(statusFile))
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		return parseOpkgStatus(file, statusFile)
+	}
+
+	return nil, nil
+}
+
+// Parse the status file, which uses the same stanzas as the dpkg status file.
+// The Status field holds want, flags and state, where the user flag marks
+// packages installed on request. The name of the file is used in the
+// returned ErrorList.
+func parseOpkgStatus(reader io.Reader, name string) ([]Package, error) {
+	stanzas, err := readControlStanzas(reader, name)
+
+	var errs ErrorList
+	errs.add(err)
+
+	var packages []Package
+
+	for _, stanza := range stanzas {
+		fields := stanza.Fields
+
+		if fields["Package"] == "" {
+			errs.addf(name, stanza.Line, "stanza without Package field")
+			continue
+		}
+
+		status := strings.Fields(fields["Status"])
+		if len(status) < 3 {
+			errs.addf(name, stanza.Line, "invalid status %q of %s", fields["Status"], fields["Package"])
+			continue
+		}
+
+		if status[len(status)-1] != "installed" {
+			continue
+		}
+
+		pkg := Package{
+			Name:          fields["Package"],
+			Version:       fields["Version"],
+			Manager:       "opkg",
+			Architecture:  fields["Architecture"],
+			InstallReason: InstallReasonAutomatic,
+		}
+
+		for _, flag := range strings.Split(status[1], ",") {
+			if flag == "user" {
+				pkg.InstallReason = InstallReasonExplicit
+			}
+		}
+
+		pkg.InstallTime, _ = strconv.ParseInt(fields["Installed-Time"], 10, 64)
+
+		packages = append(packages, pkg)
+	}
+
+	return packages, errs.err()
+}
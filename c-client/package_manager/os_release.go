@@ -0,0 +1,149 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// Files describing the distribution, in the order they are tried. The
+// os-release files are standard, the others are only read on old releases
+// without them.
+const (
+	osReleaseFile       = "/etc/os-release"
+	osReleaseFallback   = "/usr/lib/os-release"
+	osReleaseAlpineFile = "/etc/alpine-release"
+	osReleaseDebianFile = "/etc/debian_version"
+)
+
+// Files needed by ReadOsRelease
+var OsReleaseFiles = []FileSpec{
+	{Path: osReleaseFile, Optional: true},
+	{Path: osReleaseFallback, Optional: true},
+	{Path: osReleaseAlpineFile, Optional: true},
+	{Path: osReleaseDebianFile, Optional: true},
+}
+
+// The distribution and release of a scanned system, like debian, 10 and
+// buster. The version and codename are empty when unknown, like for rolling
+// releases.
+type OsRelease struct {
+	ID        string `json:"i"`
+	VersionID string `json:"v,omitempty"`
+	Codename  string `json:"c,omitempty"`
+}
+
+// Get the distribution and release of the file system from the os-release
+// file, or from the release files of Alpine and Debian when there is none
+func ReadOsRelease(fsys fs.FS) (*OsRelease, error) {
+	for _, file := range []string{osReleaseFile, osReleaseFallback} {
+		data, err := fs.ReadFile(fsys, fsName(file))
+		if err == nil {
+			return parseOsRelease(data), nil
+		}
+	}
+
+	if data, err := fs.ReadFile(fsys, fsName(osReleaseAlpineFile)); err == nil {
+		return &OsRelease{ID: "alpine", VersionID: firstLine(data)}, nil
+	}
+
+	// Testing and unstable have the codename of the next release, like
+	// bullseye/sid, instead of a version
+	if data, err := fs.ReadFile(fsys, fsName(osReleaseDebianFile)); err == nil {
+		release := &OsRelease{ID: "debian"}
+
+		version := firstLine(data)
+		if split := strings.SplitN(version, "/", 2); len(split) == 2 {
+			release.Codename = split[0]
+		} else {
+			release.VersionID = version
+		}
+
+		return release, nil
+	}
+
+	return nil, fmt.Errorf("no os-release file found")
+}
+
+// Parse an os-release file, lines are KEY=value assignments where the value
+// may be quoted like in a shell
+func parseOsRelease(data []byte) *OsRelease {
+	values := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		split := strings.SplitN(line, "=", 2)
+		if len(split) != 2 {
+			continue
+		}
+
+		values[split[0]] = unquoteOsReleaseValue(split[1])
+	}
+
+	release := &OsRelease{
+		ID:        values["ID"],
+		VersionID: values["VERSION_ID"],
+		Codename:  values["VERSION_CODENAME"],
+	}
+
+	// The default of the os-release specification
+	if release.ID == "" {
+		release.ID = "linux"
+	}
+
+	// Some Ubuntu releases only set UBUNTU_CODENAME
+	if release.Codename == "" {
+		release.Codename = values["UBUNTU_CODENAME"]
+	}
+
+	return release
+}
+
+// Remove the quotes of a value, in double quotes a backslash escapes $, ", \
+// and ` like in a shell
+func unquoteOsReleaseValue(value string) string {
+	if len(value) < 2 {
+		return value
+	}
+
+	switch {
+	case value[0] == '\'' && value[len(value)-1] == '\'':
+		return value[1 : len(value)-1]
+	case value[0] == '"' && value[len(value)-1] == '"':
+		var unquoted strings.Builder
+
+		inner := value[1 : len(value)-1]
+		for i := 0; i < len(inner); i++ {
+			if inner[i] == '\\' && i+1 < len(inner) && strings.IndexByte("$\"\\`", inner[i+1]) >= 0 {
+				i++
+			}
+			unquoted.WriteByte(inner[i])
+		}
+
+		return unquoted.String()
+	default:
+		return value
+	}
+}
+
+// The first line of a file without surrounding white space
+func firstLine(data []byte) string {
+	line := string(data)
+	if newline := strings.IndexByte(line, '\n'); newline >= 0 {
+		line = line[:newline]
+	}
+
+	return strings.TrimSpace(line)
+}
@@ -5,19 +5,228 @@ Copyright 2020 Q-Jam B.V.
 */
 package package_manager
 
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"q-jam.nl/c/c-client/package_manager/versions"
+)
+
 type PackageManager interface {
 	// Short name of the package manager
 	Id() string
 
-	// Files needed by the manager
-	FilesNeeded() []string
+	// Files, directories and glob patterns needed by the manager
+	FilesNeeded() []FileSpec
+
+	// Get the packages installed in the file system fsys, see DirFS. The
+	// packages which could be read are returned also when a file is missing
+	// or malformed, the error describes the problems, usually as ErrorList.
+	Get(fsys fs.FS) ([]Package, error)
+}
+
+type FileKind int
+
+const (
+	// A single regular file
+	RegularFile FileKind = iota
+	// A directory and everything below it
+	Directory
+	// All regular files matching a glob pattern, see MatchPath
+	GlobPattern
+)
+
+// A path in the scanned file system needed by a package manager. Of a glob
+// pattern only the files at most MaxDepth directories below its base are
+// needed and no more than MaxFiles of them, without limit when zero.
+type FileSpec struct {
+	Path     string
+	Kind     FileKind
+	Optional bool
+	MaxDepth int
+	MaxFiles int
+}
+
+// Check if the regular file at the slash separated absolute path name is part
+// of the path: the file itself, a file below the directory or a file matching
+// the glob pattern
+func (f FileSpec) Matches(name string) bool {
+	switch f.Kind {
+	case Directory:
+		return strings.HasPrefix(name, path.Clean(f.Path)+"/")
+	case GlobPattern:
+		return MatchPath(f.Path, name) && f.withinDepth(path.Dir(name))
+	default:
+		return name == path.Clean(f.Path)
+	}
+}
+
+// Check if a directory is at most MaxDepth directories below the base of a
+// glob pattern
+func (f FileSpec) withinDepth(directory string) bool {
+	if f.MaxDepth <= 0 {
+		return true
+	}
+
+	relative := strings.TrimPrefix(strings.TrimPrefix(directory, GlobBase(f.Path)), "/")
+	if relative == "" {
+		return true
+	}
 
-	// Get the packages installed according to the supplied files
-	Get(files []string) []Package
+	return strings.Count(relative, "/")+1 <= f.MaxDepth
 }
 
+// Why a package is installed, if the package manager keeps track of it
+const (
+	InstallReasonExplicit  = "explicit"
+	InstallReasonAutomatic = "automatic"
+)
+
+// Flags of a package which needs attention
+const (
+	// The package is held at its version
+	PackageFlagHeld = "held"
+	// The package is broken and has to be reinstalled before anything else
+	PackageFlagReinstRequired = "reinst-required"
+	// The candidate version comes from a security pocket, like bionic-security
+	PackageFlagSecurityUpdate = "security-update"
+)
+
+// An installed package. Times are Unix times and the size is the installed
+// size in bytes, the state is the package manager specific installation state
+// like installed or config-files. Every element of Depends is a list of
+// alternatives. The candidate version is the newest version in the local
+// package indexes, set only when it is newer than the installed version. The
+// optional fields are left empty when the package manager doesn't keep track
+// of them.
 type Package struct {
-	Name    string `json:"n"`
-	Version string `json:"v"`
-	Manager string `json:"m"`
+	Name          string         `json:"n"`
+	Version       string         `json:"v"`
+	Manager       string         `json:"m"`
+	Architecture  string         `json:"a,omitempty"`
+	InstallTime   int64          `json:"i,omitempty"`
+	InstallReason string         `json:"r,omitempty"`
+	License       string         `json:"l,omitempty"`
+	Location      string         `json:"f,omitempty"`
+	Source        string         `json:"s,omitempty"`
+	Maintainer    string         `json:"e,omitempty"`
+	Size          int64          `json:"z,omitempty"`
+	BuildTime     int64          `json:"b,omitempty"`
+	Commit        string         `json:"c,omitempty"`
+	State         string         `json:"k,omitempty"`
+	Flags         []string       `json:"g,omitempty"`
+	Depends       [][]Dependency `json:"d,omitempty"`
+	Provides      []Dependency   `json:"p,omitempty"`
+	Candidate     string         `json:"u,omitempty"`
+}
+
+// Check if the package is installed. Package managers like dpkg also keep
+// track of partially installed packages and of removed packages whose
+// configuration files are left, those have another state.
+func (p Package) Installed() bool {
+	switch p.State {
+	case "", debStateInstalled, debStateTriggersAwaited, debStateTriggersPending:
+		return true
+	default:
+		return false
+	}
+}
+
+// Split packages into the installed packages and the others, see Installed.
+// The installed packages are never nil, as they are reported as a list even
+// when there are none.
+func SplitInstalled(packages []Package) ([]Package, []Package) {
+	installed := []Package{}
+	var others []Package
+
+	for _, pkg := range packages {
+		if pkg.Installed() {
+			installed = append(installed, pkg)
+		} else {
+			others = append(others, pkg)
+		}
+	}
+
+	return installed, others
+}
+
+// The version scheme of each package manager, others can't be compared
+var versionSchemes = map[string]versions.CompareFunc{
+	"apk":   versions.CompareApk,
+	"deb":   versions.CompareDpkg,
+	"opkg":  versions.CompareDpkg,
+	"rpm":   versions.CompareRpm,
+	"npm":   versions.CompareSemver,
+	"gomod": versions.CompareGo,
+	"cargo": versions.CompareSemver,
+	"pip":   versions.ComparePep440,
+}
+
+// Compare the version of the package with another version of it, following
+// the rules of its package manager. The result is negative when the package is
+// older than version, zero when it is the same and positive when it is newer.
+func (p Package) Compare(version string) (int, error) {
+	compare, ok := versionSchemes[p.Manager]
+	if !ok {
+		return 0, fmt.Errorf("versions of %s packages can't be compared", p.Manager)
+	}
+
+	return compare(p.Version, version)
+}
+
+// Check if the file system below root has what a package manager needs. All
+// required paths have to be present, if all paths are optional at least one
+// of them has to be present. Checking stops as soon as the outcome is known.
+func Available(fsys fs.FS, files []FileSpec) bool {
+	allOptional := true
+
+	for _, file := range files {
+		if !file.Optional {
+			allOptional = false
+
+			if !Present(fsys, file) {
+				return false
+			}
+		}
+	}
+
+	if !allOptional {
+		return true
+	}
+
+	for _, file := range files {
+		if Present(fsys, file) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check if a single path is present in the file system. Searching for the
+// files of a glob pattern stops at the first one, within the limits of the
+// spec.
+func Present(fsys fs.FS, file FileSpec) bool {
+	if file.Kind == GlobPattern {
+		return len(globFiles(fsys, file, 1)) > 0
+	}
+
+	info, err := fs.Stat(fsys, fsName(file.Path))
+	if err != nil {
+		return false
+	}
+
+	if file.Kind == Directory {
+		return info.IsDir()
+	}
+
+	return info.Mode().IsRegular()
+}
+
+// Get the location of a path in the file system below root
+func Resolve(root string, file string) string {
+	return filepath.Join(root, filepath.FromSlash(file))
 }
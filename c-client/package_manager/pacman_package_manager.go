@@ -0,0 +1,116 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"bufio"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+type PacmanPackageManagerImpl struct{}
+
+// Short name of the package manager
+func (PacmanPackageManagerImpl) Id() string {
+	return "pacman"
+}
+
+// Every installed package has its own <name>-<version> directory with a desc
+// file
+const pacmanDescPattern = "/var/lib/pacman/local/*/desc"
+
+// Files needed by the manager
+func (PacmanPackageManagerImpl) FilesNeeded() []FileSpec {
+	return []FileSpec{{Path: pacmanDescPattern, Kind: GlobPattern}}
+}
+
+// Get the packages installed in the file system
+func (PacmanPackageManagerImpl) Get(fsys fs.FS) ([]Package, error) {
+	var packages []Package
+	var errs ErrorList
+
+	for _, desc := range Glob(fsys, pacmanDescPattern) {
+		pkg, err := readPacmanDesc(fsys, desc)
+		errs.add(err)
+
+		if pkg.Name != "" {
+			packages = append(packages, pkg)
+		}
+	}
+
+	return packages, errs.err()
+}
+
+// Open and parse a desc file
+func readPacmanDesc(fsys fs.FS, desc string) (Package, error) {
+	file, err := fsys.Open(fsName(desc))
+	if err != nil {
+		return Package{}, err
+	}
+	defer file.Close()
+
+	return parsePacmanDesc(file, desc)
+}
+
+// Parse a desc file, which consists of %FIELD% lines followed by one or more
+// value lines and an empty line. The name of the file is used in the returned
+// ErrorList.
+func parsePacmanDesc(reader io.Reader, name string) (Package, error) {
+	bufferedReader := bufio.NewReader(reader)
+	var errs ErrorList
+
+	pkg := Package{
+		Manager:       "pacman",
+		InstallReason: InstallReasonExplicit,
+	}
+	var field string
+
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := bufferedReader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			errs.addf(name, lineNumber, "%v", err)
+			break
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+
+		if strings.HasPrefix(line, "%") && strings.HasSuffix(line, "%") && len(line) > 1 {
+			field = line
+		} else if line == "" {
+			field = ""
+		} else {
+			// Only the first value of multi-value fields is of interest
+			switch field {
+			case "%NAME%":
+				pkg.Name = line
+			case "%VERSION%":
+				pkg.Version = line
+			case "%ARCH%":
+				pkg.Architecture = line
+			case "%INSTALLDATE%":
+				pkg.InstallTime, _ = strconv.ParseInt(line, 10, 64)
+			case "%REASON%":
+				// 0 (or no reason at all) means explicitly installed
+				if line == "1" {
+					pkg.InstallReason = InstallReasonAutomatic
+				}
+			}
+			field = ""
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	if pkg.Name == "" {
+		errs.addf(name, 0, "no %%NAME%% field")
+	}
+
+	return pkg, errs.err()
+}
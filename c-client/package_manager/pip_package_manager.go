@@ -0,0 +1,170 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// Python installation roots searched for site-packages and dist-packages
+var pipSystemRoots = []string{
+	"/usr/lib",
+	"/usr/lib64",
+	"/usr/local/lib",
+	"/usr/local/lib64",
+}
+
+// Finds the Python distributions installed with pip (or a distribution
+// package) through their dist-info and egg-info metadata. Virtualenvs are
+// searched for anywhere below VirtualenvRoots.
+type PipPackageManagerImpl struct {
+	VirtualenvRoots []string
+}
+
+// Short name of the package manager
+func (PipPackageManagerImpl) Id() string {
+	return "pip"
+}
+
+// Files needed by the manager
+func (m PipPackageManagerImpl) FilesNeeded() []FileSpec {
+	var files []FileSpec
+	for _, pattern := range m.patterns() {
+		files = append(files, FileSpec{Path: pattern, Kind: GlobPattern, Optional: true})
+	}
+
+	return files
+}
+
+// Get the packages installed in the file system
+func (m PipPackageManagerImpl) Get(fsys fs.FS) ([]Package, error) {
+	var packages []Package
+	var errs ErrorList
+	seen := make(map[string]bool)
+
+	for _, pattern := range m.patterns() {
+		for _, file := range Glob(fsys, pattern) {
+			if seen[file] {
+				continue
+			}
+			seen[file] = true
+
+			pkg, err := readPipMetadata(fsys, file)
+			errs.add(err)
+
+			if pkg.Name != "" {
+				packages = append(packages, pkg)
+			}
+		}
+	}
+
+	return packages, errs.err()
+}
+
+// The glob patterns for the metadata files. An egg-info is either a directory
+// with a PKG-INFO file or a single file with the same contents.
+func (m PipPackageManagerImpl) patterns() []string {
+	var libraries []string
+	for _, root := range pipSystemRoots {
+		libraries = append(libraries, root+"/python*")
+	}
+	for _, root := range m.VirtualenvRoots {
+		libraries = append(libraries, strings.TrimSuffix(root, "/")+"/**/lib/python*")
+	}
+
+	// Both site-packages and dist-packages (Debian) are searched
+	var patterns []string
+	for _, library := range libraries {
+		patterns = append(patterns,
+			library+"/*-packages/*.dist-info/METADATA",
+			library+"/*-packages/*.egg-info/PKG-INFO",
+			library+"/*-packages/*.egg-info",
+		)
+	}
+
+	return patterns
+}
+
+// Open and parse a metadata file
+func readPipMetadata(fsys fs.FS, file string) (Package, error) {
+	f, err := fsys.Open(fsName(file))
+	if err != nil {
+		return Package{}, err
+	}
+	defer f.Close()
+
+	return parsePipMetadata(f, file)
+}
+
+// Parse the headers of a METADATA or PKG-INFO file, the body (the long
+// description) that follows the first empty line is ignored. The name of the
+// file is used in the returned error.
+func parsePipMetadata(reader io.Reader, name string) (Package, error) {
+	bufferedReader := bufio.NewReader(reader)
+
+	pkg := Package{
+		Manager: "pip",
+	}
+	var licenseExpression string
+
+	for {
+		line, err := bufferedReader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return pkg, &ParseError{File: name, Err: err}
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+
+		if line == "" {
+			break
+		}
+
+		// Continuation lines of multi-line fields (usually the license text)
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			if err != nil {
+				break
+			}
+
+			continue
+		}
+
+		split := strings.SplitN(line, ":", 2)
+		if len(split) == 2 {
+			value := strings.TrimSpace(split[1])
+
+			switch strings.ToLower(split[0]) {
+			case "name":
+				pkg.Name = value
+			case "version":
+				pkg.Version = value
+			case "license":
+				if value != "UNKNOWN" {
+					pkg.License = value
+				}
+			case "license-expression":
+				licenseExpression = value
+			}
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	if licenseExpression != "" {
+		pkg.License = licenseExpression
+	}
+
+	if pkg.Name == "" {
+		return pkg, &ParseError{File: name, Err: errors.New("no Name field")}
+	}
+
+	return pkg, nil
+}
@@ -0,0 +1,75 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Every installed package has a /var/db/pkg/<category>/<name>-<version>
+// directory, of which only the files with upper case names (PF, LICENSE and
+// the like) are needed
+const (
+	portagePattern   = "/var/db/pkg/*/*/[A-Z]*"
+	portagePFPattern = "/var/db/pkg/*/*/PF"
+)
+
+// Splits a package name with version (PF) like font-adobe-100dpi-1.0.3-r1 into
+// the name and the version
+var portageVersionRegexp = regexp.MustCompile(`^(.+)-([0-9]+(?:\.[0-9]+)*[a-z]?(?:_(?:alpha|beta|pre|rc|p)[0-9]*)*(?:-r[0-9]+)?)$`)
+
+type PortagePackageManagerImpl struct{}
+
+// Short name of the package manager
+func (PortagePackageManagerImpl) Id() string {
+	return "portage"
+}
+
+// Files needed by the manager
+func (PortagePackageManagerImpl) FilesNeeded() []FileSpec {
+	return []FileSpec{{Path: portagePattern, Kind: GlobPattern}}
+}
+
+// Get the packages installed in the file system
+func (PortagePackageManagerImpl) Get(fsys fs.FS) ([]Package, error) {
+	var packages []Package
+	var errs ErrorList
+
+	for _, file := range Glob(fsys, portagePFPattern) {
+		directory := path.Dir(file)
+		category := path.Base(path.Dir(directory))
+
+		pf := readPortageValue(fsys, file)
+
+		match := portageVersionRegexp.FindStringSubmatch(pf)
+		if match == nil {
+			errs.addf(file, 0, "invalid package name with version %q", pf)
+			continue
+		}
+
+		packages = append(packages, Package{
+			Name:    path.Join(category, match[1]),
+			Version: match[2],
+			Manager: "portage",
+			License: readPortageValue(fsys, path.Join(directory, "LICENSE")),
+		})
+	}
+
+	return packages, errs.err()
+}
+
+// Read a single value file, missing files are empty values
+func readPortageValue(fsys fs.FS, file string) string {
+	data, err := fs.ReadFile(fsys, fsName(file))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,166 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Reader for the legacy Berkeley DB hash database used by rpm (Packages). Rather
+// than following the hash buckets, every hash page is visited and the values
+// are collected, which is all that is needed to list the headers.
+
+const (
+	bdbHashMagic    = 0x061561
+	bdbPageHeader   = 26
+	bdbMetaHeader   = 72
+	bdbHashUnsorted = 2
+	bdbOverflow     = 7
+	bdbHash         = 13
+	bdbKeyData      = 1
+	bdbOffPage      = 3
+)
+
+type bdbDatabase struct {
+	data      []byte
+	byteOrder binary.ByteOrder
+	pageSize  int
+}
+
+func isBdbHashDatabase(data []byte) bool {
+	if len(data) < 16 {
+		return false
+	}
+
+	return binary.LittleEndian.Uint32(data[12:16]) == bdbHashMagic ||
+		binary.BigEndian.Uint32(data[12:16]) == bdbHashMagic
+}
+
+// Get all header blobs stored as hash values
+func readRpmBdbBlobs(data []byte) ([][]byte, error) {
+	if len(data) < bdbMetaHeader || !isBdbHashDatabase(data) {
+		return nil, fmt.Errorf("not a Berkeley DB hash database")
+	}
+
+	db := bdbDatabase{data: data, byteOrder: binary.LittleEndian}
+	if binary.BigEndian.Uint32(data[12:16]) == bdbHashMagic {
+		db.byteOrder = binary.BigEndian
+	}
+
+	db.pageSize = int(db.byteOrder.Uint32(data[20:24]))
+	if db.pageSize < 512 || db.pageSize&(db.pageSize-1) != 0 {
+		return nil, fmt.Errorf("invalid page size %d", db.pageSize)
+	}
+
+	if data[24] != 0 {
+		return nil, fmt.Errorf("encrypted databases are not supported")
+	}
+
+	lastPage := int(db.byteOrder.Uint32(data[32:36]))
+	if pages := len(data)/db.pageSize - 1; lastPage > pages {
+		lastPage = pages
+	}
+
+	var blobs [][]byte
+
+	for pageNumber := 1; pageNumber <= lastPage; pageNumber++ {
+		page := db.page(pageNumber)
+
+		pageType := page[25]
+		if pageType != bdbHash && pageType != bdbHashUnsorted {
+			continue
+		}
+
+		entries := int(db.byteOrder.Uint16(page[20:22]))
+		if bdbPageHeader+entries*2 > len(page) {
+			return nil, fmt.Errorf("hash page %d has too many entries", pageNumber)
+		}
+
+		// Entries are key/value pairs, the values are at the odd indexes
+		for i := 1; i < entries; i += 2 {
+			offset := int(db.byteOrder.Uint16(page[bdbPageHeader+i*2:]))
+
+			// Entries are stored back to front, so each one ends where the
+			// previous one starts
+			end := int(db.byteOrder.Uint16(page[bdbPageHeader+(i-1)*2:]))
+			if offset >= len(page) || end > len(page) || end < offset {
+				return nil, fmt.Errorf("invalid entry %d on hash page %d", i, pageNumber)
+			}
+
+			switch page[offset] {
+			case bdbKeyData:
+				// rpm keeps its instance counter as a small inline value
+				if end-offset-1 < 8 {
+					continue
+				}
+
+				blobs = append(blobs, page[offset+1:end])
+			case bdbOffPage:
+				if offset+12 > len(page) {
+					return nil, fmt.Errorf("invalid entry %d on hash page %d", i, pageNumber)
+				}
+
+				overflowPage := int(db.byteOrder.Uint32(page[offset+4 : offset+8]))
+				length := int(db.byteOrder.Uint32(page[offset+8 : offset+12]))
+
+				blob, err := db.overflow(overflowPage, length)
+				if err != nil {
+					return nil, err
+				}
+
+				blobs = append(blobs, blob)
+			}
+		}
+	}
+
+	return blobs, nil
+}
+
+func (db *bdbDatabase) page(pageNumber int) []byte {
+	return db.data[pageNumber*db.pageSize : (pageNumber+1)*db.pageSize]
+}
+
+// Collect a value stored on a chain of overflow pages
+func (db *bdbDatabase) overflow(pageNumber int, length int) ([]byte, error) {
+	if length > len(db.data) {
+		return nil, fmt.Errorf("overflow value length %d exceeds database size", length)
+	}
+
+	value := make([]byte, 0, length)
+	pages := len(db.data) / db.pageSize
+
+	for pageNumber != 0 && len(value) < length {
+		if pageNumber >= pages {
+			return nil, fmt.Errorf("overflow page %d out of bounds", pageNumber)
+		}
+
+		page := db.page(pageNumber)
+		if page[25] != bdbOverflow {
+			return nil, fmt.Errorf("page %d is not an overflow page", pageNumber)
+		}
+
+		// On overflow pages the free area offset holds the number of bytes used
+		used := int(db.byteOrder.Uint16(page[22:24]))
+		if used == 0 || bdbPageHeader+used > len(page) {
+			return nil, fmt.Errorf("overflow page %d has invalid length", pageNumber)
+		}
+
+		chunk := page[bdbPageHeader : bdbPageHeader+used]
+		if len(value)+len(chunk) > length {
+			chunk = chunk[:length-len(value)]
+		}
+
+		value = append(value, chunk...)
+		pageNumber = int(db.byteOrder.Uint32(page[16:20]))
+	}
+
+	if len(value) != length {
+		return nil, fmt.Errorf("overflow chain ended early")
+	}
+
+	return value, nil
+}
@@ -0,0 +1,85 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Reader for the ndb rpmdb backend (Packages.db). The file starts with slot
+// pages pointing at header blobs stored in 16 byte blocks, everything is
+// little-endian.
+
+const (
+	ndbHeaderMagic    = 'R' | 'p'<<8 | 'm'<<16 | 'P'<<24
+	ndbSlotMagic      = 'S' | 'l'<<8 | 'o'<<16 | 't'<<24
+	ndbBlobMagic      = 'B' | 'l'<<8 | 'b'<<16 | 'S'<<24
+	ndbVersion        = 0
+	ndbPageSize       = 4096
+	ndbSlotSize       = 16
+	ndbBlockSize      = 16
+	ndbBlobHeaderSize = 16
+)
+
+func isNdbDatabase(data []byte) bool {
+	return len(data) >= 4 && binary.LittleEndian.Uint32(data[0:4]) == ndbHeaderMagic
+}
+
+// Get all header blobs referenced from the slots
+func readRpmNdbBlobs(data []byte) ([][]byte, error) {
+	if len(data) < 32 || !isNdbDatabase(data) {
+		return nil, fmt.Errorf("not an ndb database")
+	}
+
+	version := binary.LittleEndian.Uint32(data[4:8])
+	if version != ndbVersion {
+		return nil, fmt.Errorf("unsupported ndb version %d", version)
+	}
+
+	slotPages := uint64(binary.LittleEndian.Uint32(data[12:16]))
+	if slotPages == 0 || slotPages*ndbPageSize > uint64(len(data)) {
+		return nil, fmt.Errorf("invalid slot page count %d", slotPages)
+	}
+
+	var blobs [][]byte
+
+	// The database header takes up the first two slots
+	for offset := uint64(2 * ndbSlotSize); offset < slotPages*ndbPageSize; offset += ndbSlotSize {
+		slot := data[offset : offset+ndbSlotSize]
+
+		if binary.LittleEndian.Uint32(slot[0:4]) != ndbSlotMagic {
+			return nil, fmt.Errorf("invalid slot magic at offset %d", offset)
+		}
+
+		packageIndex := binary.LittleEndian.Uint32(slot[4:8])
+		if packageIndex == 0 {
+			// Free slot
+			continue
+		}
+
+		blobOffset := uint64(binary.LittleEndian.Uint32(slot[8:12])) * ndbBlockSize
+		if blobOffset+ndbBlobHeaderSize > uint64(len(data)) {
+			return nil, fmt.Errorf("blob for package %d out of bounds", packageIndex)
+		}
+
+		blobHeader := data[blobOffset : blobOffset+ndbBlobHeaderSize]
+		if binary.LittleEndian.Uint32(blobHeader[0:4]) != ndbBlobMagic ||
+			binary.LittleEndian.Uint32(blobHeader[4:8]) != packageIndex {
+			return nil, fmt.Errorf("invalid blob header for package %d", packageIndex)
+		}
+
+		blobLength := uint64(binary.LittleEndian.Uint32(blobHeader[12:16]))
+		blobStart := blobOffset + ndbBlobHeaderSize
+		if blobStart+blobLength > uint64(len(data)) {
+			return nil, fmt.Errorf("blob for package %d truncated", packageIndex)
+		}
+
+		blobs = append(blobs, data[blobStart:blobStart+blobLength])
+	}
+
+	return blobs, nil
+}
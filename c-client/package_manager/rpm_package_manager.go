@@ -0,0 +1,228 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"strconv"
+)
+
+// Locations of the rpmdb for the supported backends, in order of preference
+// in case a converted database left an old one behind
+var rpmDatabases = []string{
+	"/var/lib/rpm/rpmdb.sqlite",
+	"/var/lib/rpm/Packages.db",
+	"/var/lib/rpm/Packages",
+}
+
+// Header tags used to construct a package
+const (
+	rpmTagName    = 1000
+	rpmTagVersion = 1001
+	rpmTagRelease = 1002
+	rpmTagEpoch   = 1003
+	rpmTagArch    = 1022
+)
+
+// Header entry data types
+const (
+	rpmTypeInt32       = 4
+	rpmTypeString      = 6
+	rpmTypeStringArray = 8
+	rpmTypeI18nString  = 9
+)
+
+// Reads the rpmdb in one of the Berkeley DB, sqlite or ndb formats. The
+// format is detected from the contents of the database file.
+type RpmPackageManagerImpl struct{}
+
+// Short name of the package manager
+func (RpmPackageManagerImpl) Id() string {
+	return "rpm"
+}
+
+// Files needed by the manager
+func (RpmPackageManagerImpl) FilesNeeded() []FileSpec {
+	var files []FileSpec
+	for _, database := range rpmDatabases {
+		files = append(files, FileSpec{Path: database, Optional: true})
+	}
+
+	// The recent changes to the sqlite database, see applySqliteWal
+	files = append(files, FileSpec{Path: rpmDatabases[0] + "-wal", Optional: true})
+
+	return files
+}
+
+// Get the packages installed in the file system
+func (RpmPackageManagerImpl) Get(fsys fs.FS) ([]Package, error) {
+	var database string
+	for _, candidate := range rpmDatabases {
+		if Present(fsys, FileSpec{Path: candidate}) {
+			database = candidate
+			break
+		}
+	}
+	if database == "" {
+		return nil, nil
+	}
+
+	data, err := fs.ReadFile(fsys, fsName(database))
+	if err != nil {
+		return nil, err
+	}
+
+	var errs ErrorList
+
+	var blobs [][]byte
+	switch {
+	case isSqliteDatabase(data):
+		var walErr error
+		data, walErr = applySqliteWalFile(fsys, database, data)
+		errs.addFile(database+"-wal", walErr)
+
+		blobs, err = readRpmSqliteBlobs(data)
+	case isNdbDatabase(data):
+		blobs, err = readRpmNdbBlobs(data)
+	case isBdbHashDatabase(data):
+		blobs, err = readRpmBdbBlobs(data)
+	default:
+		err = fmt.Errorf("unknown rpmdb format")
+	}
+
+	errs.addFile(database, err)
+
+	var packages []Package
+
+	for _, blob := range blobs {
+		pkg, err := parseRpmHeader(blob)
+		if err != nil {
+			errs.addFile(database, err)
+			continue
+		}
+
+		// Imported signing keys show up as gpg-pubkey pseudo packages
+		if pkg.Name == "" || pkg.Name == "gpg-pubkey" {
+			continue
+		}
+
+		packages = append(packages, pkg)
+	}
+
+	return packages, errs.err()
+}
+
+// Parse a header blob as stored in the rpmdb. Unlike a header in an rpm file
+// the blob has no magic, it starts directly with the index entry count and
+// data length.
+func parseRpmHeader(blob []byte) (Package, error) {
+	if len(blob) < 8 {
+		return Package{}, fmt.Errorf("header too short")
+	}
+
+	indexCount := binary.BigEndian.Uint32(blob[0:4])
+	dataLength := binary.BigEndian.Uint32(blob[4:8])
+
+	dataStart := 8 + uint64(indexCount)*16
+	if dataStart+uint64(dataLength) > uint64(len(blob)) {
+		return Package{}, fmt.Errorf("header index (%d entries, %d bytes of data) exceeds blob size %d",
+			indexCount, dataLength, len(blob))
+	}
+	data := blob[dataStart : dataStart+uint64(dataLength)]
+
+	var name, version, release, arch string
+	var epoch int
+
+	for i := uint32(0); i < indexCount; i++ {
+		entry := blob[8+i*16 : 8+(i+1)*16]
+
+		tag := binary.BigEndian.Uint32(entry[0:4])
+		dataType := binary.BigEndian.Uint32(entry[4:8])
+		offset := binary.BigEndian.Uint32(entry[8:12])
+
+		if uint64(offset) >= uint64(len(data)) {
+			continue
+		}
+
+		switch tag {
+		case rpmTagName, rpmTagVersion, rpmTagRelease, rpmTagArch:
+			if dataType != rpmTypeString && dataType != rpmTypeStringArray && dataType != rpmTypeI18nString {
+				continue
+			}
+
+			value := data[offset:]
+			if end := bytes.IndexByte(value, 0); end >= 0 {
+				value = value[:end]
+			}
+
+			switch tag {
+			case rpmTagName:
+				name = string(value)
+			case rpmTagVersion:
+				version = string(value)
+			case rpmTagRelease:
+				release = string(value)
+			case rpmTagArch:
+				arch = string(value)
+			}
+		case rpmTagEpoch:
+			if dataType != rpmTypeInt32 || uint64(offset)+4 > uint64(len(data)) {
+				continue
+			}
+
+			epoch = int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		}
+	}
+
+	// Compose the version as [epoch:]version-release. An epoch of 0 is the
+	// same as none and left out, like rpm does.
+	fullVersion := version
+	if release != "" {
+		fullVersion += "-" + release
+	}
+	if epoch > 0 {
+		fullVersion = strconv.Itoa(epoch) + ":" + fullVersion
+	}
+
+	return Package{
+		Name:         name,
+		Version:      fullVersion,
+		Manager:      "rpm",
+		Architecture: arch,
+	}, nil
+}
+
+// Get all header blobs from the Packages table
+func readRpmSqliteBlobs(data []byte) ([][]byte, error) {
+	db, err := openSqliteDatabase(data)
+	if err != nil {
+		return nil, err
+	}
+
+	rootPage, err := db.tableRootPage("Packages")
+	if err != nil {
+		return nil, err
+	}
+
+	var blobs [][]byte
+	err = db.walkTable(rootPage, func(record []interface{}) {
+		// The hnum column is the rowid, the blob is the only blob column
+		for _, value := range record {
+			if blob, ok := value.([]byte); ok {
+				blobs = append(blobs, blob)
+				break
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return blobs, nil
+}
@@ -0,0 +1,62 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+// The same packages stored in each of the rpmdb formats. The headers of
+// glibc-all-langpacks are larger than a page, so they are stored on overflow
+// pages, and the gpg-pubkey pseudo package is left out. Of the sqlite database
+// in WAL mode, glibc-all-langpacks is only in the write-ahead log.
+func TestRpmDatabases(t *testing.T) {
+	expected := []Package{
+		{Name: "bash", Version: "5.1.8-2.fc35", Manager: "rpm", Architecture: "x86_64"},
+		{Name: "openssl-libs", Version: "1:1.1.1l-2.fc35", Manager: "rpm", Architecture: "x86_64"},
+		{Name: "zlib", Version: "1.2.11-30.fc35", Manager: "rpm", Architecture: "x86_64"},
+		{Name: "glibc-all-langpacks", Version: "2.34-8.fc35", Manager: "rpm", Architecture: "x86_64"},
+	}
+
+	for _, test := range []struct {
+		file     string
+		database string
+		wal      string
+	}{
+		{"rpmdb.sqlite", "var/lib/rpm/rpmdb.sqlite", ""},
+		{"rpmdb-wal.sqlite", "var/lib/rpm/rpmdb.sqlite", "rpmdb-wal.sqlite-wal"},
+		{"rpm-Packages.db", "var/lib/rpm/Packages.db", ""},
+		{"rpm-Packages", "var/lib/rpm/Packages", ""},
+	} {
+		fsys := fstest.MapFS{}
+
+		for file, name := range map[string]string{test.file: test.database, test.wal: test.database + "-wal"} {
+			if file == "" {
+				continue
+			}
+
+			data, err := os.ReadFile(filepath.Join("..", "testdata", file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fsys[name] = &fstest.MapFile{Data: data}
+		}
+
+		packages, err := RpmPackageManagerImpl{}.Get(fsys)
+		if err != nil {
+			t.Errorf("%s: %v", test.file, err)
+		}
+
+		if !reflect.DeepEqual(packages, expected) {
+			t.Errorf("%s: got %+v, expected %+v", test.file, packages, expected)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The state of snapd lists the installed snaps and their current revision
+const snapStateFile = "/var/lib/snapd/state.json"
+
+// Snaps are mounted at <mount directory>/<name>/<revision>, Fedora and the
+// like use /var/lib/snapd/snap instead of /snap
+var snapMountDirectories = []string{
+	"/snap",
+	"/var/lib/snapd/snap",
+}
+
+// Matches the top level name and version of meta/snap.yaml
+var (
+	snapNameRegexp    = regexp.MustCompile(`(?m)^name:\s*(.+?)\s*$`)
+	snapVersionRegexp = regexp.MustCompile(`(?m)^version:\s*(.+?)\s*$`)
+)
+
+type SnapPackageManagerImpl struct{}
+
+// Short name of the package manager
+func (SnapPackageManagerImpl) Id() string {
+	return "snap"
+}
+
+// Files needed by the manager
+func (SnapPackageManagerImpl) FilesNeeded() []FileSpec {
+	files := []FileSpec{{Path: snapStateFile, Optional: true}}
+	for _, directory := range snapMountDirectories {
+		files = append(files, FileSpec{Path: directory + "/*/*/meta/snap.yaml", Kind: GlobPattern, Optional: true})
+	}
+
+	return files
+}
+
+// Get the packages installed in the file system
+func (SnapPackageManagerImpl) Get(fsys fs.FS) ([]Package, error) {
+	if Present(fsys, FileSpec{Path: snapStateFile}) {
+		return readSnapState(fsys, snapStateFile)
+	}
+
+	// Without the state, all mounted snaps are reported, limited to the
+	// current revisions where the current link can be read
+	var packages []Package
+	var errs ErrorList
+
+	for _, directory := range snapMountDirectories {
+		for _, file := range Glob(fsys, directory+"/*/*/meta/snap.yaml") {
+			revisionDirectory := path.Dir(path.Dir(file))
+			current, err := readLink(fsys, path.Join(path.Dir(revisionDirectory), "current"))
+			if err == nil && path.Base(current) != path.Base(revisionDirectory) {
+				continue
+			}
+
+			name, version, err := readSnapYaml(fsys, file)
+			if err != nil {
+				errs.add(err)
+				continue
+			}
+
+			packages = append(packages, Package{
+				Name:    name,
+				Version: version,
+				Manager: "snap",
+			})
+		}
+	}
+
+	return packages, errs.err()
+}
+
+type snapState struct {
+	Data struct {
+		Snaps map[string]struct {
+			Current string `json:"current"`
+		} `json:"snaps"`
+	} `json:"data"`
+}
+
+// Read the snapd state for the installed snaps. The state only holds the
+// revision, the version is taken from the mounted snap when available and is
+// the revision otherwise.
+func readSnapState(fsys fs.FS, file string) ([]Package, error) {
+	data, err := fs.ReadFile(fsys, fsName(file))
+	if err != nil {
+		return nil, err
+	}
+
+	var state snapState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return nil, &ParseError{File: file, Err: err}
+	}
+
+	var names []string
+	for name := range state.Data.Snaps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var packages []Package
+	var errs ErrorList
+
+	for _, name := range names {
+		revision := state.Data.Snaps[name].Current
+		if revision == "" {
+			continue
+		}
+
+		pkg := Package{
+			Name:    name,
+			Version: revision,
+			Manager: "snap",
+		}
+
+		for _, directory := range snapMountDirectories {
+			snapYaml := path.Join(directory, name, revision, "meta/snap.yaml")
+			if !Present(fsys, FileSpec{Path: snapYaml}) {
+				continue
+			}
+
+			_, version, err := readSnapYaml(fsys, snapYaml)
+			errs.add(err)
+			if version != "" {
+				pkg.Version = version
+			}
+			break
+		}
+
+		packages = append(packages, pkg)
+	}
+
+	return packages, errs.err()
+}
+
+// Get the name and version from meta/snap.yaml without a full YAML parser
+func readSnapYaml(fsys fs.FS, file string) (string, string, error) {
+	data, err := fs.ReadFile(fsys, fsName(file))
+	if err != nil {
+		return "", "", err
+	}
+
+	var name, version string
+	if match := snapNameRegexp.FindSubmatch(data); match != nil {
+		name = unquoteYaml(string(match[1]))
+	}
+	if match := snapVersionRegexp.FindSubmatch(data); match != nil {
+		version = unquoteYaml(string(match[1]))
+	}
+
+	if name == "" {
+		return "", "", &ParseError{File: file, Err: fmt.Errorf("no name")}
+	}
+
+	return name, version, nil
+}
+
+// Remove the quotes of a quoted YAML scalar
+func unquoteYaml(value string) string {
+	if len(value) >= 2 && (value[0] == '\'' || value[0] == '"') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+
+	return strings.TrimSpace(value)
+}
@@ -0,0 +1,462 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// A minimal read-only sqlite reader, just enough to walk the table b-trees of
+// rpmdb.sqlite and the Nix database. Both are kept in WAL mode, so the
+// committed changes still sitting in the write-ahead log are applied first.
+
+const sqliteMagic = "SQLite format 3\x00"
+
+// The write-ahead log starts with a header, followed by frames of a header and
+// a page. The lowest bit of the magic number is set when the checksums are
+// computed on big-endian words.
+const (
+	sqliteWalMagic           = 0x377f0682
+	sqliteWalHeaderSize      = 32
+	sqliteWalFrameHeaderSize = 24
+)
+
+// B-tree page types
+const (
+	sqliteInteriorTablePage = 0x05
+	sqliteLeafTablePage     = 0x0d
+)
+
+type sqliteDatabase struct {
+	data       []byte
+	pageSize   int
+	usableSize int
+}
+
+func isSqliteDatabase(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(sqliteMagic))
+}
+
+func openSqliteDatabase(data []byte) (*sqliteDatabase, error) {
+	if len(data) < 100 || !isSqliteDatabase(data) {
+		return nil, fmt.Errorf("not a sqlite database")
+	}
+
+	pageSize := int(binary.BigEndian.Uint16(data[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return nil, fmt.Errorf("invalid sqlite page size %d", pageSize)
+	}
+
+	return &sqliteDatabase{
+		data:       data,
+		pageSize:   pageSize,
+		usableSize: pageSize - int(data[20]),
+	}, nil
+}
+
+// Apply the write-ahead log next to a database file, <file>-wal, to its
+// contents. Without a log the contents are returned as they are, with an
+// invalid log as well along with the error.
+func applySqliteWalFile(fsys fs.FS, file string, data []byte) ([]byte, error) {
+	wal, err := fs.ReadFile(fsys, fsName(file+"-wal"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return data, nil
+	}
+	if err != nil {
+		return data, err
+	}
+
+	return applySqliteWal(data, wal)
+}
+
+// Apply the pages of the transactions committed in a write-ahead log to the
+// contents of a database. Frames are valid as long as their salt matches the
+// header and the running checksum matches, the frames after the last commit
+// frame belong to an unfinished transaction. A log left behind by an earlier
+// checkpoint has another salt and changes nothing.
+func applySqliteWal(data []byte, wal []byte) ([]byte, error) {
+	// The log is truncated to nothing by some checkpoints
+	if len(wal) == 0 {
+		return data, nil
+	}
+
+	if len(wal) < sqliteWalHeaderSize {
+		return data, fmt.Errorf("write-ahead log truncated")
+	}
+
+	magic := binary.BigEndian.Uint32(wal[0:4])
+	if magic&^1 != sqliteWalMagic {
+		return data, fmt.Errorf("invalid write-ahead log")
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if magic&1 != 0 {
+		order = binary.BigEndian
+	}
+
+	pageSize := int(binary.BigEndian.Uint32(wal[8:12]))
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return data, fmt.Errorf("invalid write-ahead log page size %d", pageSize)
+	}
+	if db, err := openSqliteDatabase(data); err == nil && db.pageSize != pageSize {
+		return data, fmt.Errorf("write-ahead log page size %d differs from database page size %d", pageSize, db.pageSize)
+	}
+
+	s0, s1 := sqliteWalChecksum(order, wal[:24], 0, 0)
+	if s0 != binary.BigEndian.Uint32(wal[24:28]) || s1 != binary.BigEndian.Uint32(wal[28:32]) {
+		return data, fmt.Errorf("invalid write-ahead log header checksum")
+	}
+	salt := wal[16:24]
+
+	committed := make(map[int][]byte)
+	pending := make(map[int][]byte)
+	// The size of the database in pages after the last commit
+	pageCount := 0
+
+	for offset := sqliteWalHeaderSize; offset+sqliteWalFrameHeaderSize+pageSize <= len(wal); offset += sqliteWalFrameHeaderSize + pageSize {
+		header := wal[offset : offset+sqliteWalFrameHeaderSize]
+		page := wal[offset+sqliteWalFrameHeaderSize : offset+sqliteWalFrameHeaderSize+pageSize]
+
+		if !bytes.Equal(header[8:16], salt) {
+			break
+		}
+
+		s0, s1 = sqliteWalChecksum(order, header[:8], s0, s1)
+		s0, s1 = sqliteWalChecksum(order, page, s0, s1)
+		if s0 != binary.BigEndian.Uint32(header[16:20]) || s1 != binary.BigEndian.Uint32(header[20:24]) {
+			break
+		}
+
+		pending[int(binary.BigEndian.Uint32(header[0:4]))] = page
+
+		if commitSize := int(binary.BigEndian.Uint32(header[4:8])); commitSize != 0 {
+			for pageNumber, page := range pending {
+				committed[pageNumber] = page
+			}
+			pending = make(map[int][]byte)
+			pageCount = commitSize
+		}
+	}
+
+	if pageCount == 0 {
+		return data, nil
+	}
+
+	// The database can grow and shrink with the transactions
+	result := make([]byte, pageCount*pageSize)
+	copy(result, data)
+
+	for pageNumber, page := range committed {
+		if pageNumber >= 1 && pageNumber <= pageCount {
+			copy(result[(pageNumber-1)*pageSize:], page)
+		}
+	}
+
+	return result, nil
+}
+
+// Continue the checksum of the write-ahead log over data, a multiple of 8
+// bytes
+func sqliteWalChecksum(order binary.ByteOrder, data []byte, s0 uint32, s1 uint32) (uint32, uint32) {
+	for i := 0; i+8 <= len(data); i += 8 {
+		s0 += order.Uint32(data[i:]) + s1
+		s1 += order.Uint32(data[i+4:]) + s0
+	}
+
+	return s0, s1
+}
+
+// Find the root page of a table in the schema table
+func (db *sqliteDatabase) tableRootPage(name string) (int, error) {
+	rootPage := 0
+
+	// The schema table lives on page 1 with columns type, name, tbl_name,
+	// rootpage and sql
+	err := db.walkTable(1, func(record []interface{}) {
+		if len(record) < 4 {
+			return
+		}
+
+		recordType, _ := record[0].(string)
+		recordName, _ := record[1].(string)
+		recordRootPage, _ := record[3].(int64)
+
+		if recordType == "table" && recordName == name {
+			rootPage = int(recordRootPage)
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if rootPage == 0 {
+		return 0, fmt.Errorf("table %s not found", name)
+	}
+
+	return rootPage, nil
+}
+
+// Call visit for every record in the table b-tree starting at rootPage
+func (db *sqliteDatabase) walkTable(rootPage int, visit func(record []interface{})) error {
+	visited := make(map[int]bool)
+
+	var walk func(pageNumber int) error
+	walk = func(pageNumber int) error {
+		if visited[pageNumber] {
+			return fmt.Errorf("b-tree page %d referenced twice", pageNumber)
+		}
+		visited[pageNumber] = true
+
+		page, headerOffset, err := db.page(pageNumber)
+		if err != nil {
+			return err
+		}
+
+		if len(page) < headerOffset+8 {
+			return fmt.Errorf("b-tree page %d truncated", pageNumber)
+		}
+
+		pageType := page[headerOffset]
+		cellCount := int(binary.BigEndian.Uint16(page[headerOffset+3 : headerOffset+5]))
+
+		var cellPointers int
+		switch pageType {
+		case sqliteLeafTablePage:
+			cellPointers = headerOffset + 8
+		case sqliteInteriorTablePage:
+			cellPointers = headerOffset + 12
+		default:
+			return fmt.Errorf("unexpected page type 0x%02x on page %d", pageType, pageNumber)
+		}
+
+		if len(page) < cellPointers+cellCount*2 {
+			return fmt.Errorf("b-tree page %d truncated", pageNumber)
+		}
+
+		for i := 0; i < cellCount; i++ {
+			cellOffset := int(binary.BigEndian.Uint16(page[cellPointers+i*2:]))
+			if cellOffset >= len(page) {
+				return fmt.Errorf("cell %d on page %d out of bounds", i, pageNumber)
+			}
+			cell := page[cellOffset:]
+
+			if pageType == sqliteInteriorTablePage {
+				if len(cell) < 4 {
+					return fmt.Errorf("cell %d on page %d truncated", i, pageNumber)
+				}
+
+				err = walk(int(binary.BigEndian.Uint32(cell[0:4])))
+				if err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			payload, err := db.leafPayload(cell)
+			if err != nil {
+				return fmt.Errorf("cell %d on page %d: %v", i, pageNumber, err)
+			}
+
+			record, err := parseSqliteRecord(payload)
+			if err != nil {
+				return fmt.Errorf("cell %d on page %d: %v", i, pageNumber, err)
+			}
+
+			visit(record)
+		}
+
+		if pageType == sqliteInteriorTablePage {
+			return walk(int(binary.BigEndian.Uint32(page[headerOffset+8 : headerOffset+12])))
+		}
+
+		return nil
+	}
+
+	return walk(rootPage)
+}
+
+// Get a page and the offset of the b-tree header in it. The first page starts
+// with the database header.
+func (db *sqliteDatabase) page(pageNumber int) ([]byte, int, error) {
+	start := (pageNumber - 1) * db.pageSize
+	if pageNumber < 1 || start+db.pageSize > len(db.data) {
+		return nil, 0, fmt.Errorf("page %d out of bounds", pageNumber)
+	}
+
+	page := db.data[start : start+db.usableSize]
+	if pageNumber == 1 {
+		return page, 100, nil
+	}
+
+	return page, 0, nil
+}
+
+// Get the full payload of a table leaf cell, following overflow pages
+func (db *sqliteDatabase) leafPayload(cell []byte) ([]byte, error) {
+	payloadSize, n := sqliteVarint(cell)
+	if n == 0 {
+		return nil, fmt.Errorf("invalid payload size")
+	}
+	cell = cell[n:]
+
+	// Skip the rowid
+	_, n = sqliteVarint(cell)
+	if n == 0 {
+		return nil, fmt.Errorf("invalid rowid")
+	}
+	cell = cell[n:]
+
+	// Figure out how much of the payload is stored on the page itself
+	maxLocal := uint64(db.usableSize - 35)
+	local := payloadSize
+	if payloadSize > maxLocal {
+		minLocal := uint64((db.usableSize-12)*32/255 - 23)
+		local = minLocal + (payloadSize-minLocal)%uint64(db.usableSize-4)
+		if local > maxLocal {
+			local = minLocal
+		}
+	}
+
+	if uint64(len(cell)) < local || payloadSize > uint64(len(db.data)) {
+		return nil, fmt.Errorf("payload truncated")
+	}
+
+	payload := make([]byte, 0, payloadSize)
+	payload = append(payload, cell[:local]...)
+
+	if local == payloadSize {
+		return payload, nil
+	}
+
+	if uint64(len(cell)) < local+4 {
+		return nil, fmt.Errorf("overflow pointer truncated")
+	}
+	overflowPage := int(binary.BigEndian.Uint32(cell[local : local+4]))
+
+	for overflowPage != 0 && uint64(len(payload)) < payloadSize {
+		page, _, err := db.page(overflowPage)
+		if err != nil {
+			return nil, err
+		}
+
+		remaining := payloadSize - uint64(len(payload))
+		chunk := page[4:]
+		if uint64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+
+		payload = append(payload, chunk...)
+		overflowPage = int(binary.BigEndian.Uint32(page[0:4]))
+	}
+
+	if uint64(len(payload)) != payloadSize {
+		return nil, fmt.Errorf("overflow chain ended early")
+	}
+
+	return payload, nil
+}
+
+// Parse a record into int64, uint64 (raw float bits), string, []byte or nil
+// values
+func parseSqliteRecord(payload []byte) ([]interface{}, error) {
+	headerSize, n := sqliteVarint(payload)
+	if n == 0 || headerSize < uint64(n) || headerSize > uint64(len(payload)) {
+		return nil, fmt.Errorf("invalid record header")
+	}
+
+	header := payload[n:headerSize]
+	body := payload[headerSize:]
+
+	var record []interface{}
+
+	for len(header) > 0 {
+		serialType, n := sqliteVarint(header)
+		if n == 0 {
+			return nil, fmt.Errorf("invalid serial type")
+		}
+		header = header[n:]
+
+		var size uint64
+		switch {
+		case serialType == 0, serialType == 8, serialType == 9:
+			size = 0
+		case serialType <= 4:
+			size = serialType
+		case serialType == 5:
+			size = 6
+		case serialType == 6, serialType == 7:
+			size = 8
+		case serialType >= 12:
+			size = (serialType - 12) / 2
+		default:
+			return nil, fmt.Errorf("reserved serial type %d", serialType)
+		}
+
+		if uint64(len(body)) < size {
+			return nil, fmt.Errorf("record body truncated")
+		}
+		value := body[:size]
+		body = body[size:]
+
+		switch {
+		case serialType == 0:
+			record = append(record, nil)
+		case serialType == 8:
+			record = append(record, int64(0))
+		case serialType == 9:
+			record = append(record, int64(1))
+		case serialType <= 6:
+			// Big-endian two's complement integer
+			var v int64
+			if value[0]&0x80 != 0 {
+				v = -1
+			}
+			for _, b := range value {
+				v = v<<8 | int64(b)
+			}
+			record = append(record, v)
+		case serialType == 7:
+			record = append(record, binary.BigEndian.Uint64(value))
+		case serialType%2 == 0:
+			record = append(record, value)
+		default:
+			record = append(record, string(value))
+		}
+	}
+
+	return record, nil
+}
+
+// Decode a sqlite varint, returns the value and the number of bytes used or 0
+// if the input is truncated
+func sqliteVarint(data []byte) (uint64, int) {
+	var v uint64
+
+	for i := 0; i < 9; i++ {
+		if i >= len(data) {
+			return 0, 0
+		}
+
+		if i == 8 {
+			return v<<8 | uint64(data[i]), 9
+		}
+
+		v = v<<7 | uint64(data[i]&0x7f)
+		if data[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+
+	return v, 9
+}
@@ -0,0 +1,187 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package package_manager
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Implemented by package managers which keep track of the files of packages
+// and their checksums
+type Verifier interface {
+	// Compare the files of the installed packages with the file system fsys,
+	// see DirFS. Symbolic links are resolved within the file system when it
+	// implements SymlinkFS. The error describes the files which could not be
+	// read, like with Get.
+	Verify(fsys fs.FS) (Verification, error)
+}
+
+// The result of a verification. Only packages with modified or missing files
+// are listed. Unowned files are the files in directories owned by packages
+// which no package owns, limited to verifyUnownedDirectories.
+type Verification struct {
+	Packages []PackageVerification `json:"p,omitempty"`
+	Unowned  []string              `json:"u,omitempty"`
+}
+
+type PackageVerification struct {
+	Name     string   `json:"n"`
+	Manager  string   `json:"m"`
+	Modified []string `json:"c,omitempty"`
+	Missing  []string `json:"x,omitempty"`
+}
+
+// Directories with executables and libraries, in which unowned files are
+// reported. /usr/local is meant for software not installed by a package.
+var verifyUnownedDirectories = []string{"/bin", "/sbin", "/lib", "/lib32", "/lib64", "/libx32", "/usr"}
+
+const verifyUnownedExcluded = "/usr/local"
+
+// Result of checking a single file
+type fileStatus int
+
+const (
+	fileUnchanged fileStatus = iota
+	fileModified
+	fileMissing
+)
+
+// Check a file against a checksum calculated with newHash. The checksum of a
+// symbolic link is the checksum of its target, as is the case in apk. Without
+// a checksum only the presence of the file is checked.
+func verifyFile(fsys fs.FS, file string, newHash func() hash.Hash, checksum []byte) fileStatus {
+	info, err := lstat(fsys, file)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fileMissing
+		}
+		return fileUnchanged
+	}
+
+	if checksum == nil || newHash == nil {
+		return fileUnchanged
+	}
+
+	h := newHash()
+
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := readLink(fsys, file)
+		if err != nil {
+			return fileUnchanged
+		}
+		h.Write([]byte(target))
+	case info.Mode().IsRegular():
+		f, err := fsys.Open(fsName(file))
+		if err != nil {
+			return fileUnchanged
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return fileUnchanged
+		}
+	default:
+		return fileUnchanged
+	}
+
+	if !bytes.Equal(h.Sum(nil), checksum) {
+		return fileModified
+	}
+
+	return fileUnchanged
+}
+
+// Add the result of checking a file to the verification of a package
+func (v *PackageVerification) add(file string, status fileStatus) {
+	switch status {
+	case fileModified:
+		v.Modified = append(v.Modified, file)
+	case fileMissing:
+		v.Missing = append(v.Missing, file)
+	}
+}
+
+// Find the regular files and symbolic links directly in the owned directories
+// which are not owned themselves. Owned paths are compared with their parent
+// directory resolved, so a file owned as /bin/sh is found in /usr/bin on
+// systems with a merged /usr.
+func findUnowned(fsys fs.FS, owned []string) []string {
+	parents := make(map[string]string)
+	canonical := make(map[string]bool)
+	directories := make(map[string]bool)
+
+	for _, file := range owned {
+		canonical[canonicalPath(fsys, file, parents)] = true
+
+		info, err := lstat(fsys, file)
+		if err == nil && info.IsDir() && isUnownedDirectory(file) {
+			directories[file] = true
+		}
+	}
+
+	var unowned []string
+
+	for directory := range directories {
+		entries, _ := fs.ReadDir(fsys, fsName(directory))
+
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() && entry.Type()&fs.ModeSymlink == 0 {
+				continue
+			}
+
+			file := path.Join(directory, entry.Name())
+			if !canonical[canonicalPath(fsys, file, parents)] {
+				unowned = append(unowned, file)
+			}
+		}
+	}
+
+	sort.Strings(unowned)
+
+	return unowned
+}
+
+// Get a path with its parent directory resolved within the file system. The
+// resolved parents are cached in parents.
+func canonicalPath(fsys fs.FS, file string, parents map[string]string) string {
+	directory := path.Dir(file)
+
+	parent, ok := parents[directory]
+	if !ok {
+		parent = directory
+
+		resolved, err := evalSymlinks(fsys, directory)
+		if err == nil {
+			parent = resolved
+		}
+
+		parents[directory] = parent
+	}
+
+	return path.Join(parent, path.Base(file))
+}
+
+func isUnownedDirectory(directory string) bool {
+	if directory == verifyUnownedExcluded || strings.HasPrefix(directory, verifyUnownedExcluded+"/") {
+		return false
+	}
+
+	for _, prefix := range verifyUnownedDirectories {
+		if directory == prefix || strings.HasPrefix(directory, prefix+"/") {
+			return true
+		}
+	}
+
+	return false
+}
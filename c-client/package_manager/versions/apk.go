@@ -0,0 +1,209 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package versions
+
+import (
+	"strings"
+)
+
+// The tokens of an apk version, in the order they may appear. The version is
+// {number}{.number}...{letter}{_suffix{number}}...{-rrevision}.
+type apkToken int
+
+const (
+	apkTokenInvalid apkToken = iota - 1
+	apkTokenDigitOrZero
+	apkTokenDigit
+	apkTokenLetter
+	apkTokenSuffix
+	apkTokenSuffixNumber
+	apkTokenRevision
+	apkTokenEnd
+)
+
+// Suffixes sorting before the version without suffix, and after it
+var (
+	apkPreSuffixes  = []string{"alpha", "beta", "pre", "rc"}
+	apkPostSuffixes = []string{"cvs", "svn", "git", "hg", "p"}
+)
+
+// Compare two Alpine package versions like apk version -t. Pre-release
+// suffixes like _alpha and _rc sort before the version without suffix, others
+// like _p after it, a version with a revision -r sorts after the one without.
+// Numbers after a dot starting with a zero are compared as fractions, so
+// 1.01 < 1.1.
+func CompareApk(a string, b string) (int, error) {
+	for _, version := range []string{a, b} {
+		if !validApkVersion(version) {
+			return 0, &InvalidVersionError{Scheme: "apk", Version: version, Reason: "not a valid apk version"}
+		}
+	}
+
+	typeA, typeB := apkTokenDigit, apkTokenDigit
+	var valueA, valueB int64
+
+	for typeA == typeB && typeA != apkTokenEnd && typeA != apkTokenInvalid && valueA == valueB {
+		valueA = nextApkToken(&typeA, &a)
+		valueB = nextApkToken(&typeB, &b)
+	}
+
+	if valueA < valueB {
+		return -1, nil
+	}
+	if valueA > valueB {
+		return 1, nil
+	}
+	if typeA == typeB {
+		return 0, nil
+	}
+
+	// The leading parts are equal, now the longer version is newer unless it
+	// continues with a pre-release suffix
+	if typeA == apkTokenSuffix {
+		suffixType := typeA
+		if nextApkToken(&suffixType, &a) < 0 {
+			return -1, nil
+		}
+	}
+	if typeB == apkTokenSuffix {
+		suffixType := typeB
+		if nextApkToken(&suffixType, &b) < 0 {
+			return 1, nil
+		}
+	}
+
+	if typeA > typeB {
+		return -1, nil
+	}
+
+	return 1, nil
+}
+
+func validApkVersion(version string) bool {
+	tokenType := apkTokenDigit
+	for tokenType != apkTokenEnd && tokenType != apkTokenInvalid {
+		nextApkToken(&tokenType, &version)
+	}
+
+	return tokenType == apkTokenEnd
+}
+
+// Get the value of the token of type tokenType at the start of version and
+// advance to the next token, setting tokenType to its type
+func nextApkToken(tokenType *apkToken, version *string) int64 {
+	value := *version
+	if value == "" {
+		*tokenType = apkTokenEnd
+		return 0
+	}
+
+	var number int64
+	i := 0
+	next := apkTokenInvalid
+
+	switch *tokenType {
+	case apkTokenDigitOrZero, apkTokenDigit, apkTokenSuffixNumber, apkTokenRevision:
+		// Leading zeros after a dot make the number a fraction, the more zeros
+		// the smaller it is. The digits after the zeros are the next token.
+		if *tokenType == apkTokenDigitOrZero && value[0] == '0' {
+			for i < len(value) && value[i] == '0' {
+				i++
+			}
+			number = int64(-i)
+			if i < len(value) && isDigit(value[i]) {
+				next = apkTokenDigit
+			}
+			break
+		}
+
+		for i < len(value) && isDigit(value[i]) {
+			number = number*10 + int64(value[i]-'0')
+			i++
+		}
+	case apkTokenLetter:
+		number = int64(value[0])
+		i = 1
+	case apkTokenSuffix:
+		found := false
+		for index, suffix := range apkPreSuffixes {
+			if strings.HasPrefix(value, suffix) {
+				number = int64(index - len(apkPreSuffixes))
+				i = len(suffix)
+				found = true
+				break
+			}
+		}
+		if !found {
+			for index, suffix := range apkPostSuffixes {
+				if strings.HasPrefix(value, suffix) {
+					number = int64(index)
+					i = len(suffix)
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			*tokenType = apkTokenInvalid
+			return -1
+		}
+	default:
+		*tokenType = apkTokenInvalid
+		return -1
+	}
+
+	value = value[i:]
+	*version = value
+
+	if value == "" {
+		*tokenType = apkTokenEnd
+	} else if next != apkTokenInvalid {
+		*tokenType = next
+	} else {
+		*tokenType = nextApkTokenType(*tokenType, version)
+	}
+
+	return number
+}
+
+// Get the type of the next token after a token of type previous, skipping the
+// separator. The types have to appear in order, except for the numbers and
+// letters of the version itself.
+func nextApkTokenType(previous apkToken, version *string) apkToken {
+	value := *version
+	next := apkTokenInvalid
+
+	if (previous == apkTokenDigit || previous == apkTokenDigitOrZero) && value[0] >= 'a' && value[0] <= 'z' {
+		next = apkTokenLetter
+	} else if previous == apkTokenLetter && isDigit(value[0]) {
+		next = apkTokenDigit
+	} else if previous == apkTokenSuffix && isDigit(value[0]) {
+		next = apkTokenSuffixNumber
+	} else {
+		switch value[0] {
+		case '.':
+			next = apkTokenDigitOrZero
+		case '_':
+			next = apkTokenSuffix
+		case '-':
+			if len(value) > 1 && value[1] == 'r' {
+				next = apkTokenRevision
+				value = value[1:]
+			}
+		}
+		*version = value[1:]
+	}
+
+	if next < previous {
+		if !((next == apkTokenDigitOrZero && previous == apkTokenDigit) ||
+			(next == apkTokenSuffix && previous == apkTokenSuffixNumber) ||
+			(next == apkTokenDigit && previous == apkTokenLetter)) {
+			next = apkTokenInvalid
+		}
+	}
+
+	return next
+}
@@ -0,0 +1,162 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package versions
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// A Debian version [epoch:]upstream[-revision]
+type dpkgVersion struct {
+	epoch    int64
+	upstream string
+	revision string
+}
+
+// Compare two Debian package versions like dpkg --compare-versions. The epoch
+// is compared first, then the upstream version and the revision, where a tilde
+// sorts before anything, even the end of the version, so 1.0~rc1 < 1.0. Like
+// dpkg, invalid characters are accepted.
+func CompareDpkg(a string, b string) (int, error) {
+	versionA, err := parseDpkgVersion(a)
+	if err != nil {
+		return 0, err
+	}
+
+	versionB, err := parseDpkgVersion(b)
+	if err != nil {
+		return 0, err
+	}
+
+	if versionA.epoch != versionB.epoch {
+		if versionA.epoch < versionB.epoch {
+			return -1, nil
+		}
+		return 1, nil
+	}
+
+	if result := compareDpkgPart(versionA.upstream, versionB.upstream); result != 0 {
+		return result, nil
+	}
+
+	return compareDpkgPart(versionA.revision, versionB.revision), nil
+}
+
+func parseDpkgVersion(version string) (dpkgVersion, error) {
+	invalid := func(reason string) (dpkgVersion, error) {
+		return dpkgVersion{}, &InvalidVersionError{Scheme: "dpkg", Version: version, Reason: reason}
+	}
+
+	value := strings.TrimSpace(version)
+	if value == "" {
+		return invalid("version string is empty")
+	}
+	if strings.ContainsAny(value, " \t\n\r") {
+		return invalid("version string has embedded spaces")
+	}
+
+	var parsed dpkgVersion
+
+	if colon := strings.IndexByte(value, ':'); colon >= 0 {
+		epoch := value[:colon]
+		if epoch == "" {
+			return invalid("epoch in version is empty")
+		}
+
+		number, err := strconv.ParseInt(epoch, 10, 32)
+		if errors.Is(err, strconv.ErrRange) {
+			return invalid("epoch in version is too big")
+		} else if err != nil {
+			return invalid("epoch in version is not number")
+		} else if number < 0 {
+			return invalid("epoch in version is negative")
+		}
+
+		value = value[colon+1:]
+		if value == "" {
+			return invalid("nothing after colon in version number")
+		}
+
+		parsed.epoch = number
+	}
+
+	// The revision starts after the last hyphen, the upstream version may
+	// contain hyphens itself
+	parsed.upstream = value
+	if hyphen := strings.LastIndexByte(value, '-'); hyphen >= 0 {
+		parsed.upstream = value[:hyphen]
+		parsed.revision = value[hyphen+1:]
+
+		if parsed.revision == "" {
+			return invalid("revision number is empty")
+		}
+	}
+
+	if parsed.upstream == "" {
+		return invalid("version number is empty")
+	}
+
+	return parsed, nil
+}
+
+// Compare upstream versions or revisions, alternating between non-digit parts
+// compared character by character and numeric parts compared as numbers
+func compareDpkgPart(a string, b string) int {
+	i, j := 0, 0
+
+	for i < len(a) || j < len(b) {
+		for (i < len(a) && !isDigit(a[i])) || (j < len(b) && !isDigit(b[j])) {
+			orderA := dpkgOrder(a, i)
+			orderB := dpkgOrder(b, j)
+			if orderA != orderB {
+				return sign(orderA - orderB)
+			}
+
+			i++
+			j++
+		}
+
+		start := i
+		for i < len(a) && isDigit(a[i]) {
+			i++
+		}
+		numberA := a[start:i]
+
+		start = j
+		for j < len(b) && isDigit(b[j]) {
+			j++
+		}
+		numberB := b[start:j]
+
+		if result := compareNumbers(numberA, numberB); result != 0 {
+			return result
+		}
+	}
+
+	return 0
+}
+
+// The sort order of a character of a non-digit part, letters sort before
+// other characters and a tilde before anything, the end of the part included
+func dpkgOrder(value string, i int) int {
+	if i >= len(value) {
+		return 0
+	}
+
+	c := value[i]
+	switch {
+	case isDigit(c):
+		return 0
+	case isAlpha(c):
+		return int(c)
+	case c == '~':
+		return -1
+	default:
+		return int(c) + 256
+	}
+}
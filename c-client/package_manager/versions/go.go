@@ -0,0 +1,53 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package versions
+
+import (
+	"strings"
+)
+
+// Compare two versions of Go modules or of the Go toolchain, as found in the
+// build information of Go binaries. Module versions are semantic versions,
+// toolchain versions like go1.21.0, go1.20 and go1.21rc2 are compared as the
+// semantic versions 1.21.0, 1.20.0 and 1.21.0-rc.2.
+func CompareGo(a string, b string) (int, error) {
+	return CompareSemver(goToolchainSemver(a), goToolchainSemver(b))
+}
+
+// Get the semantic version of a Go toolchain version, other versions are
+// returned as they are
+func goToolchainSemver(version string) string {
+	value := strings.TrimPrefix(version, "go")
+	if value == version {
+		return version
+	}
+
+	// Betas and release candidates of a minor release, like go1.9beta2
+	var prerelease string
+	for _, kind := range []string{"beta", "rc"} {
+		if i := strings.Index(value, kind); i >= 0 {
+			if !isDigits(value[i+len(kind):]) {
+				return version
+			}
+
+			prerelease = "-" + kind + "." + value[i+len(kind):]
+			value = value[:i]
+			break
+		}
+	}
+
+	// The first release of a minor version before Go 1.21 has no patch
+	// number
+	switch strings.Count(value, ".") {
+	case 1:
+		value += ".0"
+	case 2:
+	default:
+		return version
+	}
+
+	return value + prerelease
+}
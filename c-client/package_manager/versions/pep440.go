@@ -0,0 +1,206 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package versions
+
+import (
+	"regexp"
+	"strings"
+)
+
+// The version pattern of PEP 440, including the alternative spellings which
+// normalize to a canonical version
+var pep440Regexp = regexp.MustCompile(`(?i)^\s*v?` +
+	`(?:(?P<epoch>[0-9]+)!)?` +
+	`(?P<release>[0-9]+(?:\.[0-9]+)*)` +
+	`(?P<pre>[-_.]?(?P<pre_l>a|b|c|rc|alpha|beta|pre|preview)[-_.]?(?P<pre_n>[0-9]+)?)?` +
+	`(?P<post>(?:-(?P<post_n1>[0-9]+))|(?:[-_.]?(?P<post_l>post|rev|r)[-_.]?(?P<post_n2>[0-9]+)?))?` +
+	`(?P<dev>[-_.]?(?P<dev_l>dev)[-_.]?(?P<dev_n>[0-9]+)?)?` +
+	`(?:\+(?P<local>[a-z0-9]+(?:[-_.][a-z0-9]+)*))?` +
+	`\s*$`)
+
+// A normalized PEP 440 version, absent numbers are empty
+type pep440Version struct {
+	epoch    string
+	release  []string
+	preLabel string
+	pre      string
+	post     string
+	dev      string
+	local    []string
+}
+
+// Compare two Python package versions following PEP 440. Alternative
+// spellings are normalized first, so 1.0alpha1 equals 1.0a1 and 1.0 equals
+// 1.0.0. Development releases sort before pre-releases, which sort before the
+// release, post-releases sort after it and local versions after the public
+// version they are based on: 1.0.dev1 < 1.0a1 < 1.0 < 1.0+local < 1.0.post1.
+func ComparePep440(a string, b string) (int, error) {
+	versionA, err := parsePep440(a)
+	if err != nil {
+		return 0, err
+	}
+
+	versionB, err := parsePep440(b)
+	if err != nil {
+		return 0, err
+	}
+
+	if result := compareNumbers(versionA.epoch, versionB.epoch); result != 0 {
+		return result, nil
+	}
+
+	// Trailing zeros of the release don't matter
+	for i := 0; i < len(versionA.release) || i < len(versionB.release); i++ {
+		numberA, numberB := "0", "0"
+		if i < len(versionA.release) {
+			numberA = versionA.release[i]
+		}
+		if i < len(versionB.release) {
+			numberB = versionB.release[i]
+		}
+
+		if result := compareNumbers(numberA, numberB); result != 0 {
+			return result, nil
+		}
+	}
+
+	if result := comparePep440Pre(versionA, versionB); result != 0 {
+		return result, nil
+	}
+
+	// Without post-release sorts before any post-release
+	if result := compareOptionalNumbers(versionA.post, versionB.post, -1); result != 0 {
+		return result, nil
+	}
+
+	// Without development release sorts after any development release
+	if result := compareOptionalNumbers(versionA.dev, versionB.dev, 1); result != 0 {
+		return result, nil
+	}
+
+	return comparePep440Local(versionA.local, versionB.local), nil
+}
+
+func parsePep440(version string) (pep440Version, error) {
+	match := pep440Regexp.FindStringSubmatch(version)
+	if match == nil {
+		return pep440Version{}, &InvalidVersionError{Scheme: "pep440", Version: version, Reason: "not a PEP 440 version"}
+	}
+
+	group := func(name string) string {
+		return strings.ToLower(match[pep440Regexp.SubexpIndex(name)])
+	}
+
+	parsed := pep440Version{
+		epoch:   group("epoch"),
+		release: strings.Split(group("release"), "."),
+	}
+
+	if group("pre") != "" {
+		switch group("pre_l") {
+		case "alpha":
+			parsed.preLabel = "a"
+		case "beta":
+			parsed.preLabel = "b"
+		case "c", "pre", "preview":
+			parsed.preLabel = "rc"
+		default:
+			parsed.preLabel = group("pre_l")
+		}
+		parsed.pre = withDefault(group("pre_n"), "0")
+	}
+
+	if group("post") != "" {
+		parsed.post = withDefault(group("post_n1")+group("post_n2"), "0")
+	}
+
+	if group("dev") != "" {
+		parsed.dev = withDefault(group("dev_n"), "0")
+	}
+
+	if local := group("local"); local != "" {
+		parsed.local = strings.FieldsFunc(local, func(r rune) bool {
+			return r == '-' || r == '_' || r == '.'
+		})
+	}
+
+	return parsed, nil
+}
+
+// Compare the pre-releases. A development release of the release itself, like
+// 1.0.dev1, sorts before all pre-releases of it.
+func comparePep440Pre(a pep440Version, b pep440Version) int {
+	rank := func(version pep440Version) int {
+		if version.preLabel == "" && version.post == "" && version.dev != "" {
+			return -1
+		}
+		if version.preLabel == "" {
+			return 1
+		}
+
+		return 0
+	}
+
+	rankA, rankB := rank(a), rank(b)
+	if rankA != rankB || rankA != 0 {
+		return sign(rankA - rankB)
+	}
+
+	// The labels a, b and rc sort alphabetically
+	if result := strings.Compare(a.preLabel, b.preLabel); result != 0 {
+		return result
+	}
+
+	return compareNumbers(a.pre, b.pre)
+}
+
+// Numeric parts of the local version sort after alphanumeric parts, which are
+// compared as strings. A version without local version sorts first.
+func comparePep440Local(a []string, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		numericA, numericB := isDigits(a[i]), isDigits(b[i])
+
+		var result int
+		switch {
+		case numericA && numericB:
+			result = compareNumbers(a[i], b[i])
+		case numericA:
+			result = 1
+		case numericB:
+			result = -1
+		default:
+			result = strings.Compare(a[i], b[i])
+		}
+		if result != 0 {
+			return result
+		}
+	}
+
+	return sign(len(a) - len(b))
+}
+
+// Compare numbers which may be absent, an absent number sorts before all
+// numbers when absent is negative and after all numbers otherwise
+func compareOptionalNumbers(a string, b string, absent int) int {
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return absent
+	case b == "":
+		return -absent
+	default:
+		return compareNumbers(a, b)
+	}
+}
+
+func withDefault(value string, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+
+	return value
+}
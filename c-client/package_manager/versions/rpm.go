@@ -0,0 +1,178 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package versions
+
+import (
+	"strings"
+)
+
+// Compare two rpm versions [epoch:]version[-release] like rpm does. A missing
+// epoch is 0 and the releases are only compared when both versions have one,
+// so 1.0 equals 1.0-1. The parts are compared with rpmvercmp.
+func CompareRpm(a string, b string) (int, error) {
+	epochA, versionA, releaseA, err := parseRpmVersion(a)
+	if err != nil {
+		return 0, err
+	}
+
+	epochB, versionB, releaseB, err := parseRpmVersion(b)
+	if err != nil {
+		return 0, err
+	}
+
+	if result := rpmvercmp(epochA, epochB); result != 0 {
+		return result, nil
+	}
+
+	if result := rpmvercmp(versionA, versionB); result != 0 {
+		return result, nil
+	}
+
+	if releaseA != "" && releaseB != "" {
+		return rpmvercmp(releaseA, releaseB), nil
+	}
+
+	return 0, nil
+}
+
+// Split a version in epoch, version and release. The epoch is the leading
+// digits before a colon and the release starts after the last hyphen.
+func parseRpmVersion(version string) (string, string, string, error) {
+	if version == "" {
+		return "", "", "", &InvalidVersionError{Scheme: "rpm", Version: version, Reason: "version is empty"}
+	}
+
+	epoch := "0"
+	value := version
+
+	digits := 0
+	for digits < len(value) && isDigit(value[digits]) {
+		digits++
+	}
+	if digits < len(value) && value[digits] == ':' {
+		if digits > 0 {
+			epoch = value[:digits]
+		}
+		value = value[digits+1:]
+	}
+
+	var release string
+	if hyphen := strings.LastIndexByte(value, '-'); hyphen >= 0 {
+		release = value[hyphen+1:]
+		value = value[:hyphen]
+	}
+
+	return epoch, value, release, nil
+}
+
+// Compare two version or release strings with the rpmvercmp algorithm of rpm.
+// The strings are split in alphabetic and numeric segments, other characters
+// only separate segments. Numeric segments are newer than alphabetic ones, a
+// tilde sorts before anything, even the end of the string, and a caret sorts
+// after the end of the string but before anything else.
+func rpmvercmp(a string, b string) int {
+	if a == b {
+		return 0
+	}
+
+	isSeparator := func(c byte) bool {
+		return !isDigit(c) && !isAlpha(c) && c != '~' && c != '^'
+	}
+
+	i, j := 0, 0
+
+	for i < len(a) || j < len(b) {
+		for i < len(a) && isSeparator(a[i]) {
+			i++
+		}
+		for j < len(b) && isSeparator(b[j]) {
+			j++
+		}
+
+		endA, endB := i >= len(a), j >= len(b)
+
+		if (!endA && a[i] == '~') || (!endB && b[j] == '~') {
+			if endA || a[i] != '~' {
+				return 1
+			}
+			if endB || b[j] != '~' {
+				return -1
+			}
+
+			i++
+			j++
+			continue
+		}
+
+		if (!endA && a[i] == '^') || (!endB && b[j] == '^') {
+			if endA {
+				return -1
+			}
+			if endB {
+				return 1
+			}
+			if a[i] != '^' {
+				return 1
+			}
+			if b[j] != '^' {
+				return -1
+			}
+
+			i++
+			j++
+			continue
+		}
+
+		if endA || endB {
+			break
+		}
+
+		// Take the segment of the type of the segment of a from both
+		isSegment := isAlpha
+		numeric := isDigit(a[i])
+		if numeric {
+			isSegment = isDigit
+		}
+
+		startA, startB := i, j
+		for i < len(a) && isSegment(a[i]) {
+			i++
+		}
+		for j < len(b) && isSegment(b[j]) {
+			j++
+		}
+		segmentA, segmentB := a[startA:i], b[startB:j]
+
+		// Numeric segments are newer than alphabetic ones
+		if segmentB == "" {
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+
+		var result int
+		if numeric {
+			result = compareNumbers(segmentA, segmentB)
+		} else {
+			result = strings.Compare(segmentA, segmentB)
+		}
+		if result != 0 {
+			return result
+		}
+	}
+
+	if i >= len(a) && j >= len(b) {
+		return 0
+	}
+
+	// The version with characters left is newer
+	if i >= len(a) {
+		return -1
+	}
+
+	return 1
+}
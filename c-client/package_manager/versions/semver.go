@@ -0,0 +1,139 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package versions
+
+import (
+	"strings"
+)
+
+// A semantic version MAJOR.MINOR.PATCH[-prerelease][+build]
+type semver struct {
+	major      string
+	minor      string
+	patch      string
+	prerelease []string
+}
+
+// Compare two semantic versions following Semantic Versioning 2.0.0, as used
+// by npm, Go modules and Cargo. A leading v, like in Go module versions, is
+// ignored. A pre-release sorts before the release, pre-release identifiers are
+// compared one by one, numerically when they are numbers. Build metadata is
+// ignored, so v1.0.0+incompatible equals v1.0.0.
+func CompareSemver(a string, b string) (int, error) {
+	versionA, err := parseSemver(a)
+	if err != nil {
+		return 0, err
+	}
+
+	versionB, err := parseSemver(b)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, numbers := range [][2]string{
+		{versionA.major, versionB.major},
+		{versionA.minor, versionB.minor},
+		{versionA.patch, versionB.patch},
+	} {
+		if result := compareNumbers(numbers[0], numbers[1]); result != 0 {
+			return result, nil
+		}
+	}
+
+	// A version without pre-release is newer than one with
+	if len(versionA.prerelease) == 0 || len(versionB.prerelease) == 0 {
+		return sign(len(versionB.prerelease) - len(versionA.prerelease)), nil
+	}
+
+	for i := 0; i < len(versionA.prerelease) && i < len(versionB.prerelease); i++ {
+		if result := compareSemverIdentifiers(versionA.prerelease[i], versionB.prerelease[i]); result != 0 {
+			return result, nil
+		}
+	}
+
+	return sign(len(versionA.prerelease) - len(versionB.prerelease)), nil
+}
+
+func parseSemver(version string) (semver, error) {
+	invalid := func(reason string) (semver, error) {
+		return semver{}, &InvalidVersionError{Scheme: "semver", Version: version, Reason: reason}
+	}
+
+	value := strings.TrimPrefix(version, "v")
+
+	if plus := strings.IndexByte(value, '+'); plus >= 0 {
+		if !validSemverIdentifiers(value[plus+1:], false) {
+			return invalid("invalid build metadata")
+		}
+		value = value[:plus]
+	}
+
+	var parsed semver
+
+	if hyphen := strings.IndexByte(value, '-'); hyphen >= 0 {
+		prerelease := value[hyphen+1:]
+		if !validSemverIdentifiers(prerelease, true) {
+			return invalid("invalid pre-release")
+		}
+		parsed.prerelease = strings.Split(prerelease, ".")
+		value = value[:hyphen]
+	}
+
+	numbers := strings.Split(value, ".")
+	if len(numbers) != 3 {
+		return invalid("not MAJOR.MINOR.PATCH")
+	}
+
+	for _, number := range numbers {
+		if !isDigits(number) || (len(number) > 1 && number[0] == '0') {
+			return invalid("invalid version number")
+		}
+	}
+
+	parsed.major, parsed.minor, parsed.patch = numbers[0], numbers[1], numbers[2]
+
+	return parsed, nil
+}
+
+// Check dot separated identifiers, which are not empty and consist of ASCII
+// alphanumerics and hyphens. Numeric pre-release identifiers can't have
+// leading zeros.
+func validSemverIdentifiers(identifiers string, prerelease bool) bool {
+	for _, identifier := range strings.Split(identifiers, ".") {
+		if identifier == "" {
+			return false
+		}
+
+		for i := 0; i < len(identifier); i++ {
+			if !isDigit(identifier[i]) && !isAlpha(identifier[i]) && identifier[i] != '-' {
+				return false
+			}
+		}
+
+		if prerelease && isDigits(identifier) && len(identifier) > 1 && identifier[0] == '0' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Numeric identifiers are compared numerically and sort before alphanumeric
+// identifiers, which are compared in ASCII order
+func compareSemverIdentifiers(a string, b string) int {
+	numericA, numericB := isDigits(a), isDigits(b)
+
+	switch {
+	case numericA && numericB:
+		return compareNumbers(a, b)
+	case numericA:
+		return -1
+	case numericB:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
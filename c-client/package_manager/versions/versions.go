@@ -0,0 +1,71 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+
+// Package versions compares version strings following the rules of the
+// package managers and language ecosystems, like dpkg, apk and rpm do.
+package versions
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Compare two versions, the result is negative when a is older than b, zero
+// when they are equal and positive when a is newer than b. An error is
+// returned when either version is invalid in the scheme.
+type CompareFunc func(a string, b string) (int, error)
+
+// A version which doesn't follow the rules of a version scheme
+type InvalidVersionError struct {
+	Scheme  string
+	Version string
+	Reason  string
+}
+
+func (e *InvalidVersionError) Error() string {
+	return fmt.Sprintf("invalid %s version %q: %s", e.Scheme, e.Version, e.Reason)
+}
+
+// Compare two non-negative decimal numbers of any length
+func compareNumbers(a string, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+
+	if len(a) != len(b) {
+		return sign(len(a) - len(b))
+	}
+
+	return strings.Compare(a, b)
+}
+
+func sign(value int) int {
+	if value < 0 {
+		return -1
+	}
+	if value > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigits(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if !isDigit(value[i]) {
+			return false
+		}
+	}
+
+	return value != ""
+}
@@ -0,0 +1,134 @@
+/*
+
+Copyright 2020 Q-Jam B.V.
+
+*/
+package versions
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var schemes = map[string]CompareFunc{
+	"dpkg":   CompareDpkg,
+	"apk":    CompareApk,
+	"rpm":    CompareRpm,
+	"semver": CompareSemver,
+	"pep440": ComparePep440,
+	"go":     CompareGo,
+}
+
+var relations = map[string]int{
+	"<":  -1,
+	"==": 0,
+	">":  1,
+}
+
+// Check the comparisons in testdata/<scheme>.txt, both ways around
+func TestCompare(t *testing.T) {
+	for scheme, compare := range schemes {
+		lines := readTestdata(t, filepath.Join("testdata", scheme+".txt"))
+
+		for _, line := range lines {
+			if strings.HasPrefix(line, "!") {
+				version := strings.TrimSpace(line[1:])
+				if _, err := compare(version, version); err == nil {
+					t.Errorf("%s: %q is valid, expected an error", scheme, version)
+				}
+				continue
+			}
+
+			fields := strings.Fields(line)
+			if len(fields) != 3 {
+				t.Fatalf("%s: malformed test %q", scheme, line)
+			}
+
+			expected, ok := relations[fields[1]]
+			if !ok {
+				t.Fatalf("%s: malformed test %q", scheme, line)
+			}
+
+			if result, err := compare(fields[0], fields[2]); err != nil {
+				t.Errorf("%s: %s: %v", scheme, line, err)
+			} else if result != expected {
+				t.Errorf("%s: %s: got %d", scheme, line, result)
+			}
+
+			if result, err := compare(fields[2], fields[0]); err != nil {
+				t.Errorf("%s: %s: %v", scheme, line, err)
+			} else if result != -expected {
+				t.Errorf("%s: %s reversed: got %d", scheme, line, result)
+			}
+		}
+	}
+}
+
+// All versions of the installed package databases in the testdata of the
+// client are valid, and sorting them gives a consistent order
+func TestInstalledVersions(t *testing.T) {
+	for _, test := range []struct {
+		file    string
+		prefix  string
+		compare CompareFunc
+	}{
+		{"debian-status", "Version: ", CompareDpkg},
+		{"apk-installed", "V:", CompareApk},
+	} {
+		var versions []string
+		for _, line := range readTestdata(t, filepath.Join("..", "..", "testdata", test.file)) {
+			if strings.HasPrefix(line, test.prefix) {
+				versions = append(versions, strings.TrimPrefix(line, test.prefix))
+			}
+		}
+
+		if len(versions) == 0 {
+			t.Fatalf("%s: no versions", test.file)
+		}
+
+		for _, version := range versions {
+			if result, err := test.compare(version, version); err != nil || result != 0 {
+				t.Errorf("%s: %q compared to itself: %d, %v", test.file, version, result, err)
+			}
+		}
+
+		sort.Slice(versions, func(i, j int) bool {
+			result, _ := test.compare(versions[i], versions[j])
+			return result < 0
+		})
+
+		for i := 1; i < len(versions); i++ {
+			if result, _ := test.compare(versions[i-1], versions[i]); result > 0 {
+				t.Errorf("%s: %q sorted before %q", test.file, versions[i-1], versions[i])
+			}
+		}
+	}
+}
+
+// Read the lines of a test file without empty lines and comments
+func readTestdata(t *testing.T, file string) []string {
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var lines []string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return lines
+}
@@ -25,6 +25,9 @@ class ApiV1Report {
         val koin = KoinContextHandler.get()
         val logger = FluentLogger.forEnclosingClass()
 
+        // Newer clients may send fields this server does not know about yet
+        private val json = Json { ignoreUnknownKeys = true }
+
         fun install(application: Application) {
             application.routing {
                 post("/api/v1/report") {
@@ -53,7 +56,7 @@ class ApiV1Report {
                     val reportAsJson = call.receiveText()
                     println(reportAsJson)
 
-                    val report = Json.decodeFromString(Report.serializer(), reportAsJson)
+                    val report = json.decodeFromString(Report.serializer(), reportAsJson)
 
                     /*
                      * Store report
@@ -126,8 +129,15 @@ class ApiV1Report {
 
                         if (dockerContainerIds != null) {
                             report.dockerContainers!!.forEach { dockerContainer ->
+                                // Containers sharing the packages of their image refer to it
+                                val packages = if (dockerContainer.imagePackages) {
+                                    dockerContainer.imageId?.let { report.images?.get(it) }?.packages
+                                } else {
+                                    dockerContainer.packages
+                                }
+
                                 storePackageDetails(
-                                    dockerContainer.packages!!,
+                                    packages ?: emptyList(),
                                     dockerContainerIds[dockerContainer.id]!!,
                                     report.time
                                 )
@@ -13,6 +13,7 @@ data class Report(
     @SerialName("t") val time: Long,
     @SerialName("p") val packages: List<Package>?,
     @SerialName("d") val dockerContainers: List<DockerContainer>?,
+    @SerialName("i") val images: Map<String, DockerImage>? = null,
 )
 
 @Serializable
@@ -22,9 +23,19 @@ data class Package(
     @SerialName("m") val manager: String,
 )
 
+// When imagePackages is set the container has the packages of its image,
+// which is in Report.images
 @Serializable
 data class DockerContainer(
     @SerialName("i") val id: String,
     @SerialName("m") val image: String,
-    @SerialName("p") val packages: List<Package>?
+    @SerialName("p") val packages: List<Package>?,
+    @SerialName("j") val imageId: String? = null,
+    @SerialName("b") val imagePackages: Boolean = false,
+)
+
+@Serializable
+data class DockerImage(
+    @SerialName("i") val id: String,
+    @SerialName("p") val packages: List<Package>?,
 )